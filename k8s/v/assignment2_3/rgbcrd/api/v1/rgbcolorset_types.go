@@ -0,0 +1,97 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RGBColorDefinition defines one color RGB resources may use.
+type RGBColorDefinition struct {
+	// Name of the color as written in spec.color and spec.palette.
+	Name RGBColor `json:"name"`
+
+	// Hex value of the color, such as #ff0000.
+	// +kubebuilder:validation:Pattern=`^#[0-9a-fA-F]{6}$`
+	// +optional
+	Hex string `json:"hex,omitempty"`
+
+	// What the color stands for, such as the release channel it tags.
+	// +optional
+	Description string `json:"description,omitempty"`
+}
+
+// RGBColorSetSpec defines the colors of a RGBColorSet
+type RGBColorSetSpec struct {
+	// +kubebuilder:validation:MinItems=1
+	// +listType=map
+	// +listMapKey=name
+	Colors []RGBColorDefinition `json:"colors"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster,shortName=rgbcs
+
+// RGBColorSet lists colors RGB resources may use. The allowed colors are
+// those of all RGBColorSets together, Red, Green and Blue while there are
+// none.
+type RGBColorSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RGBColorSetSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RGBColorSetList contains a list of RGBColorSet
+type RGBColorSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RGBColorSet `json:"items"`
+}
+
+// BuiltinColors are allowed while no RGBColorSet exists.
+var BuiltinColors = []RGBColor{RedColor, GreenColor, BlueColor}
+
+// AllowedColors returns the colors defined by the RGBColorSets in the
+// cluster, or BuiltinColors if there are none.
+func AllowedColors(ctx context.Context, c client.Reader) (map[RGBColor]bool, error) {
+	var colorSets RGBColorSetList
+	if err := c.List(ctx, &colorSets); err != nil {
+		return nil, err
+	}
+	allowed := map[RGBColor]bool{}
+	if len(colorSets.Items) == 0 {
+		for _, color := range BuiltinColors {
+			allowed[color] = true
+		}
+	}
+	for _, colorSet := range colorSets.Items {
+		for _, color := range colorSet.Spec.Colors {
+			allowed[color.Name] = true
+		}
+	}
+	return allowed, nil
+}
+
+func init() {
+	SchemeBuilder.Register(&RGBColorSet{}, &RGBColorSetList{})
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var rgbcolorsetlog = logf.Log.WithName("rgbcolorset-resource")
+
+func (r *RGBColorSet) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-kd-kb-example-com-v1-rgbcolorset,mutating=false,failurePolicy=fail,sideEffects=None,groups=kd.kb.example.com,resources=rgbcolorsets,verbs=create;update,versions=v1,name=vrgbcolorset.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &RGBColorSet{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBColorSet) ValidateCreate() error {
+	rgbcolorsetlog.Info("validate create", "name", r.Name)
+
+	return r.toInvalid(r.validateColors())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBColorSet) ValidateUpdate(old runtime.Object) error {
+	rgbcolorsetlog.Info("validate update", "name", r.Name)
+
+	return r.toInvalid(r.validateColors())
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBColorSet) ValidateDelete() error {
+	// RGB resources still using a removed color are reported by the
+	// controller, the webhook is not registered for delete.
+	return nil
+}
+
+// validateColors checks that every color is defined once. The schema covers
+// the rest, this catches what it can not express.
+func (r *RGBColorSet) validateColors() field.ErrorList {
+	var allErrs field.ErrorList
+	colorsPath := field.NewPath("spec", "colors")
+	seen := map[RGBColor]bool{}
+	for i, color := range r.Spec.Colors {
+		if seen[color.Name] {
+			allErrs = append(allErrs, field.Duplicate(colorsPath.Index(i).Child("name"), color.Name))
+		}
+		seen[color.Name] = true
+	}
+	return allErrs
+}
+
+// toInvalid wraps field errors into the Invalid status error the API server
+// hands back to the user, or returns nil when there are none.
+func (r *RGBColorSet) toInvalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "RGBColorSet"},
+		r.Name, allErrs)
+}
@@ -0,0 +1,276 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultMinCount and DefaultMaxCount bound spec.count in namespaces
+	// no RGBPolicy sets either bound for.
+	DefaultMinCount int32 = 2
+	DefaultMaxCount int32 = 5
+)
+
+// RGBPolicySpec defines the limits a RGBPolicy puts on RGB resources
+type RGBPolicySpec struct {
+	// Namespaces of the RGB resources the policy applies to. An empty
+	// selector selects every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Lowest spec.count allowed.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinCount *int32 `json:"minCount,omitempty"`
+
+	// Highest spec.count allowed.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxCount *int32 `json:"maxCount,omitempty"`
+
+	// Kinds RGB resources may manage, any kind when empty.
+	// +optional
+	AllowedKinds []RGBSupportedKind `json:"allowedKinds,omitempty"`
+
+	// Image prefixes the children may run, such as "registry.example.com/",
+	// any image when empty. A prefix ends at a path, tag or digest boundary:
+	// "nginx" allows "nginx:1.21" and "nginx/extras" but not "nginx-evil".
+	// +optional
+	AllowedImages []string `json:"allowedImages,omitempty"`
+
+	// Highest number of children all RGB resources of a namespace may ask
+	// for together, the sum of their spec.count.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxChildrenPerNamespace *int32 `json:"maxChildrenPerNamespace,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster,shortName=rgbpol
+//+kubebuilder:printcolumn:name="Min",type=integer,JSONPath=".spec.minCount"
+//+kubebuilder:printcolumn:name="Max",type=integer,JSONPath=".spec.maxCount"
+//+kubebuilder:printcolumn:name="Quota",type=integer,JSONPath=".spec.maxChildrenPerNamespace"
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
+
+// RGBPolicy limits the RGB resources of the namespaces it selects. When
+// several policies select a namespace, all of their limits apply.
+type RGBPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RGBPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RGBPolicyList contains a list of RGBPolicy
+type RGBPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RGBPolicy `json:"items"`
+}
+
+// RGBLimits are the limits of all RGBPolicies selecting a namespace, taken
+// together.
+// +kubebuilder:object:generate=false
+type RGBLimits struct {
+	MinCount int32
+	MaxCount int32
+	// AllowedKinds is nil when any kind is allowed.
+	AllowedKinds map[RGBSupportedKind]bool
+	// AllowedImages holds the prefixes of every policy restricting images,
+	// an image has to match one prefix of each.
+	AllowedImages [][]string
+	// MaxChildren is nil when the namespace has no quota.
+	MaxChildren *int32
+	// Policies names the policies the limits come from.
+	Policies []string
+}
+
+// LimitsFor returns the limits of the RGBPolicies selecting namespace.
+func LimitsFor(ctx context.Context, c client.Reader, namespace string) (*RGBLimits, error) {
+	var policies RGBPolicyList
+	if err := c.List(ctx, &policies); err != nil {
+		return nil, err
+	}
+	var ns corev1.Namespace
+	if err := c.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		return nil, err
+	}
+
+	limits := &RGBLimits{MinCount: -1, MaxCount: -1}
+	for _, policy := range policies.Items {
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("RGBPolicy %s: %w", policy.Name, err)
+		}
+		if policy.Spec.NamespaceSelector != nil && !selector.Matches(labels.Set(ns.Labels)) {
+			continue
+		}
+		limits.add(&policy)
+	}
+	switch {
+	case limits.MinCount < 0 && limits.MaxCount < 0:
+		limits.MinCount, limits.MaxCount = DefaultMinCount, DefaultMaxCount
+	case limits.MinCount < 0:
+		limits.MinCount = 0
+	case limits.MaxCount < 0:
+		limits.MaxCount = math.MaxInt32
+	}
+	// Policies at odds leave no valid count, the maximum wins so that
+	// nothing gets created beyond it.
+	if limits.MinCount > limits.MaxCount {
+		limits.MinCount = limits.MaxCount
+	}
+	sort.Strings(limits.Policies)
+	return limits, nil
+}
+
+// add tightens the limits by those of policy.
+func (l *RGBLimits) add(policy *RGBPolicy) {
+	l.Policies = append(l.Policies, policy.Name)
+	if min := policy.Spec.MinCount; min != nil && *min > l.MinCount {
+		l.MinCount = *min
+	}
+	if max := policy.Spec.MaxCount; max != nil && (l.MaxCount < 0 || *max < l.MaxCount) {
+		l.MaxCount = *max
+	}
+	if len(policy.Spec.AllowedKinds) > 0 {
+		allowed := map[RGBSupportedKind]bool{}
+		for _, kind := range policy.Spec.AllowedKinds {
+			if l.AllowedKinds == nil || l.AllowedKinds[kind] {
+				allowed[kind] = true
+			}
+		}
+		l.AllowedKinds = allowed
+	}
+	if len(policy.Spec.AllowedImages) > 0 {
+		l.AllowedImages = append(l.AllowedImages, policy.Spec.AllowedImages)
+	}
+	if quota := policy.Spec.MaxChildrenPerNamespace; quota != nil && (l.MaxChildren == nil || *quota < *l.MaxChildren) {
+		l.MaxChildren = quota
+	}
+}
+
+// KindAllowed reports whether RGB resources may manage kind.
+func (l *RGBLimits) KindAllowed(kind RGBSupportedKind) bool {
+	return l.AllowedKinds == nil || l.AllowedKinds[kind]
+}
+
+// ImageAllowed reports whether children may run image.
+func (l *RGBLimits) ImageAllowed(image string) bool {
+	for _, prefixes := range l.AllowedImages {
+		allowed := false
+		for _, prefix := range prefixes {
+			if imageHasPrefix(image, prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// imageHasPrefix reports whether image starts with prefix up to a registry,
+// repository, tag or digest boundary.
+func imageHasPrefix(image string, prefix string) bool {
+	if !strings.HasPrefix(image, prefix) {
+		return false
+	}
+	if len(image) == len(prefix) || strings.HasSuffix(prefix, "/") {
+		return true
+	}
+	switch image[len(prefix)] {
+	case '/', ':', '@':
+		return true
+	}
+	return false
+}
+
+// Check returns how the RGB resource breaks the limits. images are the
+// images its children run, others the sum of spec.count of the other RGB
+// resources with children in the same namespace.
+func (l *RGBLimits) Check(r *RGBResourceManager, images []string, others int32) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	by := fmt.Sprintf(" (RGBPolicy %s)", strings.Join(l.Policies, ", "))
+	if len(l.Policies) == 0 {
+		by = ""
+	}
+
+	if r.Spec.Count < l.MinCount {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("count"), r.Spec.Count,
+			fmt.Sprintf("must be at least %d%s", l.MinCount, by)))
+	}
+	if r.Spec.Count > l.MaxCount {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("count"), r.Spec.Count,
+			fmt.Sprintf("must be at most %d%s", l.MaxCount, by)))
+	}
+	if l.MaxChildren != nil && others+r.Spec.Count > *l.MaxChildren {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("count"),
+			fmt.Sprintf("namespace quota of %d children exceeded, %d in use by other RGB resources%s", *l.MaxChildren, others, by)))
+	}
+	if !l.KindAllowed(r.Spec.Kind) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("kind"),
+			fmt.Sprintf("kind %s is not allowed%s", r.Spec.Kind, by)))
+	}
+	for _, image := range images {
+		if !l.ImageAllowed(image) {
+			allErrs = append(allErrs, field.Forbidden(specPath,
+				fmt.Sprintf("image %q is not allowed%s", image, by)))
+		}
+	}
+	return allErrs
+}
+
+// NamespaceCount returns the sum of spec.count of the RGB resources with
+// children in namespace, wherever they live, other than exclude.
+func NamespaceCount(ctx context.Context, c client.Reader, namespace string, exclude client.ObjectKey) (int32, error) {
+	var rgbs RGBResourceManagerList
+	if err := c.List(ctx, &rgbs); err != nil {
+		return 0, err
+	}
+	total := int32(0)
+	for _, rgb := range rgbs.Items {
+		if rgb.ChildNamespace() != namespace || client.ObjectKeyFromObject(&rgb) == exclude {
+			continue
+		}
+		if rgb.DeletionTimestamp == nil {
+			total += rgb.Spec.Count
+		}
+	}
+	return total, nil
+}
+
+func init() {
+	SchemeBuilder.Register(&RGBPolicy{}, &RGBPolicyList{})
+}
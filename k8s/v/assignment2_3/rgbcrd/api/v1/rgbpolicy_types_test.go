@@ -0,0 +1,126 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestLimitsForCounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []RGBPolicySpec
+		wantMin  int32
+		wantMax  int32
+	}{
+		{
+			name:    "no policy",
+			wantMin: DefaultMinCount,
+			wantMax: DefaultMaxCount,
+		},
+		{
+			name:     "only a maximum",
+			policies: []RGBPolicySpec{{MaxCount: int32Ptr(1)}},
+			wantMin:  0,
+			wantMax:  1,
+		},
+		{
+			name:     "only a minimum",
+			policies: []RGBPolicySpec{{MinCount: int32Ptr(3)}},
+			wantMin:  3,
+			wantMax:  math.MaxInt32,
+		},
+		{
+			name:     "the tightest bounds win",
+			policies: []RGBPolicySpec{{MinCount: int32Ptr(1), MaxCount: int32Ptr(6)}, {MinCount: int32Ptr(2), MaxCount: int32Ptr(4)}},
+			wantMin:  2,
+			wantMax:  4,
+		},
+		{
+			name:     "a minimum above the maximum is clamped",
+			policies: []RGBPolicySpec{{MinCount: int32Ptr(4)}, {MaxCount: int32Ptr(3)}},
+			wantMin:  3,
+			wantMax:  3,
+		},
+		{
+			name: "policies selecting other namespaces do not count",
+			policies: []RGBPolicySpec{{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "other"}},
+				MaxCount:          int32Ptr(1),
+			}},
+			wantMin: DefaultMinCount,
+			wantMax: DefaultMaxCount,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			_ = AddToScheme(scheme)
+			objs := []client.Object{&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}
+			for i, spec := range tt.policies {
+				objs = append(objs, &RGBPolicy{ObjectMeta: metav1.ObjectMeta{Name: string(rune('a' + i))}, Spec: spec})
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+
+			limits, err := LimitsFor(context.Background(), c, "default")
+			if err != nil {
+				t.Fatalf("LimitsFor() error = %v", err)
+			}
+			if limits.MinCount != tt.wantMin || limits.MaxCount != tt.wantMax {
+				t.Errorf("LimitsFor() = %d to %d, want %d to %d", limits.MinCount, limits.MaxCount, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestImageAllowed(t *testing.T) {
+	tests := []struct {
+		image    string
+		prefixes []string
+		want     bool
+	}{
+		{"nginx", []string{"nginx"}, true},
+		{"nginx:1.21", []string{"nginx"}, true},
+		{"nginx@sha256:0123", []string{"nginx"}, true},
+		{"nginx/extras", []string{"nginx"}, true},
+		{"nginx-evil/x", []string{"nginx"}, false},
+		{"registry.example.com/team/app", []string{"registry.example.com/"}, true},
+		{"registry.example.com/team/app", []string{"registry.example.com/team"}, true},
+		{"registry.example.com/team-b/app", []string{"registry.example.com/team"}, false},
+		{"registry.example.com.evil/app", []string{"registry.example.com"}, false},
+		{"busybox", []string{"nginx", "busybox"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			limits := &RGBLimits{AllowedImages: [][]string{tt.prefixes}}
+			if got := limits.ImageAllowed(tt.image); got != tt.want {
+				t.Errorf("ImageAllowed(%q) with %v = %v, want %v", tt.image, tt.prefixes, got, tt.want)
+			}
+		})
+	}
+}
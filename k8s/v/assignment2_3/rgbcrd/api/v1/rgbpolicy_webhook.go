@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var rgbpolicylog = logf.Log.WithName("rgbpolicy-resource")
+
+func (r *RGBPolicy) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-kd-kb-example-com-v1-rgbpolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=kd.kb.example.com,resources=rgbpolicies,verbs=create;update,versions=v1,name=vrgbpolicy.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &RGBPolicy{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBPolicy) ValidateCreate() error {
+	rgbpolicylog.Info("validate create", "name", r.Name)
+
+	return r.toInvalid(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBPolicy) ValidateUpdate(old runtime.Object) error {
+	rgbpolicylog.Info("validate update", "name", r.Name)
+
+	return r.toInvalid(r.validateSpec())
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBPolicy) ValidateDelete() error {
+	// Nothing to validate on delete, the webhook is not registered for it.
+	return nil
+}
+
+// validateSpec checks the selector parses, the count bounds are in order
+// and no image prefix is empty.
+func (r *RGBPolicy) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.NamespaceSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(r.Spec.NamespaceSelector, specPath.Child("namespaceSelector"))...)
+	}
+	if r.Spec.MinCount != nil && r.Spec.MaxCount != nil && *r.Spec.MinCount > *r.Spec.MaxCount {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("minCount"), *r.Spec.MinCount, "must not be greater than maxCount"))
+	}
+	for i, prefix := range r.Spec.AllowedImages {
+		if prefix == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("allowedImages").Index(i), "an empty prefix would allow every image"))
+		}
+	}
+	return allErrs
+}
+
+// toInvalid wraps field errors into the Invalid status error the API server
+// hands back to the user, or returns nil when there are none.
+func (r *RGBPolicy) toInvalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "RGBPolicy"},
+		r.Name, allErrs)
+}
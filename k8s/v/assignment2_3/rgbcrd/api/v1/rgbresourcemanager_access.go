@@ -0,0 +1,113 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// targetNamespaceAccessPath serves targetNamespaceAccess, next to the paths
+// the webhook builder registers.
+const targetNamespaceAccessPath = "/validate-kd-kb-example-com-v1-rgbresourcemanager-access"
+
+//+kubebuilder:webhook:path=/validate-kd-kb-example-com-v1-rgbresourcemanager-access,mutating=false,failurePolicy=fail,sideEffects=None,groups=kd.kb.example.com,resources=rgbresourcemanagers,verbs=create;update,versions=v1,name=vrgbresourcemanageraccess.kb.io,admissionReviewVersions={v1,v1beta1}
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// targetNamespaceAccess only lets users place children in another namespace
+// when they may create objects of the kind there themselves. The controller
+// creates them with its own cluster-wide rights, so without this check
+// anyone allowed to write RGB resources in their namespace could run pods
+// in any other. It is a handler of its own because webhook.Validator does
+// not get to see who makes the request.
+type targetNamespaceAccess struct {
+	client  client.Client
+	mapper  meta.RESTMapper
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &targetNamespaceAccess{}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (a *targetNamespaceAccess) InjectDecoder(d *admission.Decoder) error {
+	a.decoder = d
+	return nil
+}
+
+// Handle reviews the access of the requesting user on create, and on
+// updates that change the spec.
+func (a *targetNamespaceAccess) Handle(ctx context.Context, req admission.Request) admission.Response {
+	rgb := &RGBResourceManager{}
+	if err := a.decoder.Decode(req, rgb); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if rgb.Spec.TargetNamespace == "" || rgb.Spec.TargetNamespace == rgb.Namespace {
+		return admission.Allowed("")
+	}
+	if req.Operation == admissionv1.Update {
+		old := &RGBResourceManager{}
+		if err := a.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		// Finalizers and labels come and go, the controller's own updates
+		// among them.
+		if reflect.DeepEqual(old.Spec, rgb.Spec) {
+			return admission.Allowed("")
+		}
+	}
+
+	gvk := rgb.ChildGroupVersionKind()
+	mapping, err := a.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return admission.Denied(fmt.Sprintf("spec.targetNamespace: can not check access to %s: %v", gvk, err))
+	}
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range req.UserInfo.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: rgb.Spec.TargetNamespace,
+				Verb:      "create",
+				Group:     mapping.Resource.Group,
+				Version:   mapping.Resource.Version,
+				Resource:  mapping.Resource.Resource,
+			},
+			User:   req.UserInfo.Username,
+			Groups: req.UserInfo.Groups,
+			UID:    req.UserInfo.UID,
+			Extra:  extra,
+		},
+	}
+	if err := a.client.Create(ctx, review); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !review.Status.Allowed {
+		return admission.Denied(fmt.Sprintf("spec.targetNamespace: %s may not create %s in namespace %q",
+			req.UserInfo.Username, mapping.Resource.Resource, rgb.Spec.TargetNamespace))
+	}
+	return admission.Allowed("")
+}
@@ -17,47 +17,165 @@ limitations under the License.
 package v1
 
 import (
+	"encoding/json"
+	"sort"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 // RGBColor describes describes which color is applied to a resource.
-// Only one of the following colors may be specified.
-// If none of the following colors is specified, the default one
-// is Red.
-// +kubebuilder:validation:Enum=Red;Green;Blue
+// The colors defined by RGBColorSets may be specified, or Red, Green and
+// Blue while there are none. Colors end up as label values.
+// If none is specified, the default one is Red.
+// +kubebuilder:validation:MaxLength=63
+// +kubebuilder:validation:Pattern=`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`
 type RGBColor string
 
 const (
 	RedColor   RGBColor = "Red"
 	GreenColor RGBColor = "Green"
-	Blue       RGBColor = "Blue"
+	BlueColor  RGBColor = "Blue"
+
+	// Blue is the former name of BlueColor.
+	//
+	// Deprecated: use BlueColor.
+	Blue = BlueColor
+
+	// DefaultColor is applied when a RGBResourceManager does not name a color.
+	DefaultColor = RedColor
 )
 
-// +kubebuilder:validation:Enum=core;apps
+// DefaultImage is run by the children of RGB resources without a template
+// or manifest.
+const DefaultImage = "nginx"
+
+// ProtectedAnnotation set to "true" on a child keeps the controller from
+// deleting it, to scale down, to replace it or because it terminated.
+// Deleting the RGB resource still applies its deletion policy.
+const ProtectedAnnotation = "kd.kb.example.com/protected"
+
+// RGBSupportedGroup is the API group of the managed resource, core for the
+// legacy group. Without a manifest it has to be the group of one of the
+// built-in kinds.
 type RGBSupportedGroup string
 
 const (
-	CoreGrp string = "core"
-	AppsGrp string = "apps"
+	CoreGrp  string = "core"
+	AppsGrp  string = "apps"
+	BatchGrp string = "batch"
 )
 
-// +kubebuilder:validation:Enum=v1
+// RGBSupportedVersion is the API version of the managed resource. Without a
+// manifest only v1 is accepted.
 type RGBSupportedVersion string
 
 const (
 	VerV1 string = "v1"
 )
 
-// +kubebuilder:validation:Enum=Pod;Deployment
+// RGBSupportedKind is the kind of the managed resource. Without a manifest
+// it has to be one of the built-in kinds: Pod, Deployment, StatefulSet,
+// DaemonSet, ReplicaSet or Job.
+// +kubebuilder:validation:MinLength=1
 type RGBSupportedKind string
 
 const (
-	PodRc        string = "Pod"
-	DeploymentRc string = "Deployment"
+	PodRc         string = "Pod"
+	DeploymentRc  string = "Deployment"
+	StatefulSetRc string = "StatefulSet"
+	DaemonSetRc   string = "DaemonSet"
+	ReplicaSetRc  string = "ReplicaSet"
+	JobRc         string = "Job"
+)
+
+// RGBPaletteEntry is one color of a palette, with either the exact number of
+// children of that color or its weight among the children no count claims.
+type RGBPaletteEntry struct {
+	Color RGBColor `json:"color"`
+
+	// Exact number of children of this color. Can not be combined with weight.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Count *int32 `json:"count,omitempty"`
+
+	// Relative share of the children no count claims, 1 when neither count
+	// nor weight is set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Weight *int32 `json:"weight,omitempty"`
+}
+
+// RGBColorStatus counts the children of one color.
+type RGBColorStatus struct {
+	Color RGBColor `json:"color"`
+	// Number of children the palette asks for.
+	Desired int32 `json:"desired"`
+	// Number of children carrying the color.
+	Replicas int32 `json:"replicas"`
+	// Number of children carrying the color that are serving.
+	ReadyReplicas int32 `json:"readyReplicas"`
+}
+
+// RGBDeletionPolicy decides what happens to the children when their
+// RGBResourceManager is deleted.
+// +kubebuilder:validation:Enum=Delete;Orphan;Retain
+type RGBDeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the children along with the RGB resource.
+	DeletionPolicyDelete RGBDeletionPolicy = "Delete"
+	// DeletionPolicyOrphan keeps the children and drops everything tying them
+	// to the RGB resource, a new RGB resource of the same name starts afresh.
+	DeletionPolicyOrphan RGBDeletionPolicy = "Orphan"
+	// DeletionPolicyRetain keeps the children along with their RGB labels,
+	// only the owner references are removed so the garbage collector leaves
+	// them alone.
+	DeletionPolicyRetain RGBDeletionPolicy = "Retain"
+)
+
+// RGBScaleDownPolicy decides which children go first when there are more
+// than requested. Whatever the policy, ties are broken like the ReplicaSet
+// controller does: unscheduled, then not running, then not ready children
+// first, then the ones with more restarts, then the newer ones.
+// +kubebuilder:validation:Enum=NotReadyFirst;Newest;Oldest;ByColor;LeastRecentlyRestarted;HighestOrdinal
+type RGBScaleDownPolicy string
+
+const (
+	// ScaleDownNotReadyFirst applies the ReplicaSet controller ranking alone.
+	ScaleDownNotReadyFirst RGBScaleDownPolicy = "NotReadyFirst"
+	// ScaleDownNewest deletes the most recently created children first.
+	ScaleDownNewest RGBScaleDownPolicy = "Newest"
+	// ScaleDownOldest deletes the least recently created children first.
+	ScaleDownOldest RGBScaleDownPolicy = "Oldest"
+	// ScaleDownByColor deletes the children of colors over their share first.
+	ScaleDownByColor RGBScaleDownPolicy = "ByColor"
+	// ScaleDownLeastRecentlyRestarted deletes the children whose containers
+	// were (re)started longest ago first.
+	ScaleDownLeastRecentlyRestarted RGBScaleDownPolicy = "LeastRecentlyRestarted"
+	// ScaleDownHighestOrdinal deletes the children with the highest ordinal
+	// first, like a StatefulSet. Children without one, named before ordinal
+	// naming, go before all others.
+	ScaleDownHighestOrdinal RGBScaleDownPolicy = "HighestOrdinal"
+)
+
+// RGBNamingPolicy decides how new children are named. Existing children keep
+// their names.
+// +kubebuilder:validation:Enum=Ordinal;UUID
+type RGBNamingPolicy string
+
+const (
+	// NamingOrdinal names children <name>-0, <name>-1, ... A new child takes
+	// the lowest ordinal that is free, so a replaced child gets its old name
+	// back once the previous one is gone.
+	NamingOrdinal RGBNamingPolicy = "Ordinal"
+	// NamingUUID names children <name>-<random UUID>, as they used to be.
+	NamingUUID RGBNamingPolicy = "UUID"
 )
 
 // +kubebuilder:validation:Enum=Initial;Ready
@@ -68,21 +186,146 @@ const (
 	RGBReady   string = "Ready"
 )
 
+// Condition types reported in RGBResourceManagerStatus.Conditions.
+const (
+	// ConditionAvailable is True once the requested children exist and are ready.
+	ConditionAvailable string = "Available"
+	// ConditionProgressing is True while children are being created, deleted
+	// or recolored.
+	ConditionProgressing string = "Progressing"
+	// ConditionDegraded is True when the last reconcile could not make progress.
+	ConditionDegraded string = "Degraded"
+	// ConditionDrifted is True when children were recently found changed
+	// by someone else and set back. The message names the fields and who
+	// changed them.
+	ConditionDrifted string = "Drifted"
+	// ConditionPaused is True while spec.paused freezes the children.
+	ConditionPaused string = "Paused"
+)
+
+// Reasons used for the conditions above.
+const (
+	ReasonAsExpected        string = "AsExpected"
+	ReasonScalingUp         string = "ScalingUp"
+	ReasonScalingDown       string = "ScalingDown"
+	ReasonWaitingForReady   string = "WaitingForReady"
+	ReasonRecoloring        string = "Recoloring"
+	ReasonRollingOut        string = "RollingOut"
+	ReasonListFailed        string = "ListFailed"
+	ReasonCreateFailed      string = "CreateFailed"
+	ReasonDeleteFailed      string = "DeleteFailed"
+	ReasonUpdateFailed      string = "UpdateFailed"
+	ReasonUnsupportedKind   string = "UnsupportedKind"
+	ReasonKindNotFound      string = "KindNotFound"
+	ReasonAccessDenied      string = "AccessDenied"
+	ReasonCleaningUp        string = "CleaningUp"
+	ReasonCleanupFailed     string = "CleanupFailed"
+	ReasonCleanupStuck      string = "CleanupStuck"
+	ReasonCleanupComplete   string = "CleanupComplete"
+	ReasonUnknownColor      string = "UnknownColor"
+	ReasonNamespaceNotFound string = "NamespaceNotFound"
+	ReasonPolicyViolation   string = "PolicyViolation"
+	ReasonDriftRepaired     string = "DriftRepaired"
+	ReasonRepairFailed      string = "RepairFailed"
+	ReasonPaused            string = "Paused"
+	ReasonProtected         string = "Protected"
+	ReasonInvalidSelector   string = "InvalidSelector"
+	ReasonClaimFailed       string = "ClaimFailed"
+)
+
 // RGBResourceManagerSpec defines the desired state of RGBResourceManager
 type RGBResourceManagerSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
 	// Color that will be applied to created resources by RGBResourceManager.
+	// Changing it recolors the existing resources as well. With a palette it
+	// only goes to the children the palette has no color for.
+	// +kubebuilder:default=Red
+	// +optional
 	Color RGBColor `json:"color,omitempty"`
 
-	Group   RGBSupportedGroup   `json:"group"`
-	Version RGBSupportedVersion `json:"version"`
+	// Colors to spread the children across. Counts are satisfied first, in
+	// order, the remaining children are split by weight. Children are
+	// recolored, or replaced where they can not be, as count or palette
+	// change.
+	// +listType=map
+	// +listMapKey=color
+	// +optional
+	Palette []RGBPaletteEntry `json:"palette,omitempty"`
+
+	// Group of the managed resource. Defaulted from Kind when omitted.
+	// +optional
+	Group RGBSupportedGroup `json:"group,omitempty"`
+	// +kubebuilder:default=v1
+	// +optional
+	Version RGBSupportedVersion `json:"version,omitempty"`
 	Kind    RGBSupportedKind    `json:"kind"`
 
-	// Number of instances
-	// +kubebuilder:validation:Minimum=2
-	// +kubebuilder:validation:Maximum=5
+	// Template of the pods the children run: a Pod is created from it, the
+	// other kinds run it. Defaults to a single nginx container. The
+	// controller's own labels, such as color, take precedence over the
+	// template's. Changes roll out to existing children, children that can
+	// not be updated in place (Pods, ReplicaSets and Jobs) are replaced one
+	// at a time.
+	// +optional
+	Template *corev1.PodTemplateSpec `json:"template,omitempty"`
+
+	// Manifest the children are built from, which turns on the generic mode:
+	// any namespaced kind served by the cluster can be managed, named by
+	// group, version and kind. The controller sets name, namespace and its
+	// own labels, the rest is copied as is and changes are applied to the
+	// existing children. Children count as ready unless their status has a
+	// Ready or Available condition that is not True. The manager needs RBAC
+	// for the kind. Can not be combined with template.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Manifest *runtime.RawExtension `json:"manifest,omitempty"`
+
+	// Namespace the children are created in. Defaults to the namespace of the
+	// RGB resource. Children in another namespace are cleaned up by the
+	// controller rather than by owner references. Can not be changed.
+	// +optional
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+
+	// Selector the children are claimed by, like a ReplicaSet does: objects
+	// of the kind in the namespace of the RGB resource that match and have no
+	// controller are adopted, children that stop matching are released. It
+	// has to match the labels of new children. Defaults to the labels the
+	// controller sets, app=rgb and rgb=<name>, which also brings back the
+	// children a RGB resource of the same name retained. Children in a
+	// target namespace are tied by labels alone and never claimed.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// What happens to the children when the RGB resource is deleted: Delete
+	// removes them, Orphan leaves them running without any tie to the RGB
+	// resource, Retain leaves them running with their RGB labels.
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy RGBDeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// How new children are named.
+	// +kubebuilder:default=Ordinal
+	// +optional
+	NamingPolicy RGBNamingPolicy `json:"namingPolicy,omitempty"`
+
+	// Which children are deleted first when there are more than count.
+	// Defaults to HighestOrdinal with ordinal naming, NotReadyFirst otherwise.
+	// +optional
+	ScaleDownPolicy RGBScaleDownPolicy `json:"scaleDownPolicy,omitempty"`
+
+	// Paused freezes the children as they are: none is created, deleted or
+	// updated until it is unset. The status is still kept up to date.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// Number of instances. Also exposed through the scale subresource. The
+	// bounds come from the RGBPolicies selecting the namespace of the
+	// children, 2 to 5 when none sets them. The scale subresource gets past
+	// the webhook, so the controller keeps at least the minimum and creates
+	// no children beyond the maximum.
+	// +kubebuilder:validation:Minimum=0
 	Count int32 `json:"count"`
 }
 
@@ -91,16 +334,69 @@ type RGBResourceManagerStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// A list of pointers to currently managed resources.
+	// A list of pointers to currently managed resources, refreshed on every
+	// reconcile.
 	// +optional
 	Active []corev1.ObjectReference `json:"active,omitempty"`
 
 	Result RGBStatus `json:"result"`
+
+	// Number of children currently managed. Pods that terminated are not
+	// counted, they are replaced.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Number of children that are serving: Pods Ready, Deployments Available.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// Number of children carrying the current color and pod template. For
+	// Deployments this includes the rollout of their pods.
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+
+	// Number of children carrying the protected annotation, which the
+	// controller does not delete.
+	// +optional
+	ProtectedReplicas int32 `json:"protectedReplicas,omitempty"`
+
+	// Children per color, in palette order.
+	// +optional
+	Colors []RGBColorStatus `json:"colors,omitempty"`
+
+	// Label selector, in string form, matching the pods run for this RGB
+	// resource. Used by the scale subresource. Empty when the children live
+	// in spec.targetNamespace: the scale subresource only looks for pods in
+	// the namespace of the RGB resource, so a HorizontalPodAutoscaler can
+	// not scale such RGB resources.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// The generation observed by the controller when it last updated the status.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Latest observations of the RGB resource (Available, Progressing, Degraded).
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.count,statuspath=.status.replicas,selectorpath=.status.selector
 //+kubebuilder:resource:shortName=rgb
+//+kubebuilder:printcolumn:name="Kind",type=string,JSONPath=".spec.kind"
+//+kubebuilder:printcolumn:name="Color",type=string,JSONPath=".spec.color"
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=".spec.count",description="Number of children requested"
+//+kubebuilder:printcolumn:name="Current",type=integer,JSONPath=".status.replicas",description="Number of children managed"
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=".status.readyReplicas",description="Number of children ready"
+//+kubebuilder:printcolumn:name="Paused",type=boolean,JSONPath=".spec.paused",priority=1
+//+kubebuilder:printcolumn:name="Protected",type=integer,JSONPath=".status.protectedReplicas",description="Number of children the controller does not delete",priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
 
 // RGBResourceManager is the Schema for the rgbresourcemanagers API
 type RGBResourceManager struct {
@@ -111,6 +407,71 @@ type RGBResourceManager struct {
 	Status RGBResourceManagerStatus `json:"status,omitempty"`
 }
 
+// ChildGroupVersionKind returns the group, version and kind of the children,
+// with the core group spelled as the empty string the API machinery uses.
+func (r *RGBResourceManager) ChildGroupVersionKind() schema.GroupVersionKind {
+	group := string(r.Spec.Group)
+	if group == CoreGrp {
+		group = ""
+	}
+	return schema.GroupVersionKind{Group: group, Version: string(r.Spec.Version), Kind: string(r.Spec.Kind)}
+}
+
+// ChildNamespace returns the namespace the children are created in.
+func (r *RGBResourceManager) ChildNamespace() string {
+	if r.Spec.TargetNamespace != "" {
+		return r.Spec.TargetNamespace
+	}
+	return r.Namespace
+}
+
+// Images returns the container images the children run. For a manifest
+// every string under an "image" key counts, whatever the kind.
+func (r *RGBResourceManager) Images() []string {
+	if r.Spec.Manifest != nil {
+		var obj interface{}
+		if err := json.Unmarshal(r.Spec.Manifest.Raw, &obj); err != nil {
+			return nil
+		}
+		return imagesIn(obj, nil)
+	}
+	if r.Spec.Template == nil {
+		return []string{DefaultImage}
+	}
+	var images []string
+	for _, c := range r.Spec.Template.Spec.InitContainers {
+		images = append(images, c.Image)
+	}
+	for _, c := range r.Spec.Template.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	return images
+}
+
+// imagesIn appends the "image" strings found anywhere in obj to images.
+func imagesIn(obj interface{}, images []string) []string {
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if image, ok := v[key].(string); ok && key == "image" {
+				images = append(images, image)
+				continue
+			}
+			images = imagesIn(v[key], images)
+		}
+	case []interface{}:
+		for _, item := range v {
+			images = imagesIn(item, images)
+		}
+	}
+	return images
+}
+
 //+kubebuilder:object:root=true
 
 // RGBResourceManagerList contains a list of RGBResourceManager
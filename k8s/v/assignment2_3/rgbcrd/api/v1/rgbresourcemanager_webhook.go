@@ -0,0 +1,380 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var rgbresourcemanagerlog = logf.Log.WithName("rgbresourcemanager-resource")
+
+// supportedKindGroups maps every kind an RGBResourceManager can manage to
+// the API group that kind lives in.
+var supportedKindGroups = map[RGBSupportedKind]RGBSupportedGroup{
+	RGBSupportedKind(PodRc):         RGBSupportedGroup(CoreGrp),
+	RGBSupportedKind(DeploymentRc):  RGBSupportedGroup(AppsGrp),
+	RGBSupportedKind(StatefulSetRc): RGBSupportedGroup(AppsGrp),
+	RGBSupportedKind(DaemonSetRc):   RGBSupportedGroup(AppsGrp),
+	RGBSupportedKind(ReplicaSetRc):  RGBSupportedGroup(AppsGrp),
+	RGBSupportedKind(JobRc):         RGBSupportedGroup(BatchGrp),
+}
+
+// webhookClient looks up the RGBColorSets and RGBPolicies, the webhook
+// interfaces have no way to hand it over. Set by SetupWebhookWithManager.
+var webhookClient client.Reader
+
+func (r *RGBResourceManager) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	webhookClient = mgr.GetClient()
+	mgr.GetWebhookServer().Register(targetNamespaceAccessPath, &webhook.Admission{
+		Handler: &targetNamespaceAccess{client: mgr.GetClient(), mapper: mgr.GetRESTMapper()},
+	})
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-kd-kb-example-com-v1-rgbresourcemanager,mutating=true,failurePolicy=fail,sideEffects=None,groups=kd.kb.example.com,resources=rgbresourcemanagers,verbs=create;update,versions=v1,name=mrgbresourcemanager.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &RGBResourceManager{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *RGBResourceManager) Default() {
+	rgbresourcemanagerlog.Info("default", "name", r.Name)
+
+	if r.Spec.Color == "" {
+		r.Spec.Color = DefaultColor
+	}
+	if r.Spec.Version == "" {
+		r.Spec.Version = RGBSupportedVersion(VerV1)
+	}
+	if r.Spec.DeletionPolicy == "" {
+		r.Spec.DeletionPolicy = DeletionPolicyDelete
+	}
+	if r.Spec.NamingPolicy == "" {
+		r.Spec.NamingPolicy = NamingOrdinal
+	}
+	if r.Spec.ScaleDownPolicy == "" {
+		r.Spec.ScaleDownPolicy = ScaleDownNotReadyFirst
+		if r.Spec.NamingPolicy == NamingOrdinal {
+			r.Spec.ScaleDownPolicy = ScaleDownHighestOrdinal
+		}
+	}
+	// The group is implied by the kind, so fill it in rather than making
+	// every manifest repeat it. An unknown kind is left for validation.
+	if group, ok := supportedKindGroups[r.Spec.Kind]; ok && r.Spec.Group == "" {
+		r.Spec.Group = group
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-kd-kb-example-com-v1-rgbresourcemanager,mutating=false,failurePolicy=fail,sideEffects=None,groups=kd.kb.example.com,resources=rgbresourcemanagers,verbs=create;update,versions=v1,name=vrgbresourcemanager.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &RGBResourceManager{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBResourceManager) ValidateCreate() error {
+	rgbresourcemanagerlog.Info("validate create", "name", r.Name)
+
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validatePalette(field.NewPath("spec", "palette"))...)
+	allErrs = append(allErrs, r.validateColors(nil)...)
+	allErrs = append(allErrs, r.validatePolicy(nil)...)
+	return r.toInvalid(allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBResourceManager) ValidateUpdate(old runtime.Object) error {
+	rgbresourcemanagerlog.Info("validate update", "name", r.Name)
+
+	oldRGB, ok := old.(*RGBResourceManager)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a RGBResourceManager but got a %T", old))
+	}
+
+	// The scale subresource changes the spec past the webhook, checking it
+	// on metadata updates, such as adding or removing the finalizer, could
+	// keep the RGB resource from ever being deleted.
+	if r.DeletionTimestamp != nil || reflect.DeepEqual(r.Spec, oldRGB.Spec) {
+		return nil
+	}
+
+	allErrs := r.validateSpec()
+	if r.Spec.Count != oldRGB.Spec.Count || !reflect.DeepEqual(r.Spec.Palette, oldRGB.Spec.Palette) {
+		allErrs = append(allErrs, r.validatePalette(field.NewPath("spec", "palette"))...)
+	}
+	allErrs = append(allErrs, r.validateColors(oldRGB)...)
+	allErrs = append(allErrs, r.validatePolicy(oldRGB)...)
+
+	// The controller only knows how to manage children of the kind it
+	// created them with, so the managed resource can not be switched.
+	specPath := field.NewPath("spec")
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(r.Spec.Group, oldRGB.Spec.Group, specPath.Child("group"))...)
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(r.Spec.Kind, oldRGB.Spec.Kind, specPath.Child("kind"))...)
+	// Moving the children would orphan the ones in the old namespace.
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(r.Spec.TargetNamespace, oldRGB.Spec.TargetNamespace, specPath.Child("targetNamespace"))...)
+
+	return r.toInvalid(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *RGBResourceManager) ValidateDelete() error {
+	// Nothing to validate on delete, the webhook is not registered for it.
+	return nil
+}
+
+// validateSpec checks that group, version and kind together name a
+// resource the controller can manage. With a manifest any kind goes, the
+// controller resolves it against the cluster.
+func (r *RGBResourceManager) validateSpec() field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.TargetNamespace != "" {
+		for _, msg := range apivalidation.ValidateNamespaceName(r.Spec.TargetNamespace, false) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("targetNamespace"), r.Spec.TargetNamespace, msg))
+		}
+	}
+
+	if r.Spec.Template != nil {
+		allErrs = append(allErrs, validateTemplate(r.Spec.Template, specPath.Child("template"))...)
+		// Job pods have to terminate, the Job controller rejects Always.
+		if r.Spec.Kind == RGBSupportedKind(JobRc) && r.Spec.Template.Spec.RestartPolicy == corev1.RestartPolicyAlways {
+			allErrs = append(allErrs, field.NotSupported(specPath.Child("template", "spec", "restartPolicy"),
+				r.Spec.Template.Spec.RestartPolicy, []string{string(corev1.RestartPolicyOnFailure), string(corev1.RestartPolicyNever)}))
+		}
+	}
+
+	if r.Spec.Selector != nil {
+		selectorPath := specPath.Child("selector")
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(r.Spec.Selector, selectorPath)...)
+		// An empty selector matches everything, every orphan would be adopted.
+		if len(r.Spec.Selector.MatchLabels)+len(r.Spec.Selector.MatchExpressions) == 0 {
+			allErrs = append(allErrs, field.Invalid(selectorPath, r.Spec.Selector, "empty selector is not allowed"))
+		}
+	}
+
+	if r.Spec.Manifest != nil {
+		return append(allErrs, r.validateManifest(specPath)...)
+	}
+
+	group, ok := supportedKindGroups[r.Spec.Kind]
+	if !ok {
+		supported := make([]string, 0, len(supportedKindGroups))
+		for kind := range supportedKindGroups {
+			supported = append(supported, string(kind))
+		}
+		sort.Strings(supported)
+		return append(allErrs, field.NotSupported(specPath.Child("kind"), r.Spec.Kind, supported))
+	}
+
+	if r.Spec.Group != group {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("group"), r.Spec.Group,
+			fmt.Sprintf("kind %s belongs to group %q", r.Spec.Kind, group)))
+	}
+	if r.Spec.Version != RGBSupportedVersion(VerV1) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("version"), r.Spec.Version,
+			fmt.Sprintf("kind %s is only served as version %q", r.Spec.Kind, VerV1)))
+	}
+
+	return allErrs
+}
+
+// validateColors checks that the colors in use are allowed by the
+// RGBColorSets. On update only newly used colors are checked, so removing a
+// color from a RGBColorSet does not lock the RGB resources still using it.
+func (r *RGBResourceManager) validateColors(old *RGBResourceManager) field.ErrorList {
+	if webhookClient == nil {
+		return nil
+	}
+	var inUse map[RGBColor]bool
+	if old != nil {
+		inUse = old.colorsInUse()
+	}
+
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	allowed, err := AllowedColors(context.Background(), webhookClient)
+	if err != nil {
+		return append(allErrs, field.InternalError(specPath, err))
+	}
+	check := func(fldPath *field.Path, color RGBColor) {
+		if color == "" || inUse[color] || allowed[color] {
+			return
+		}
+		allErrs = append(allErrs, field.Invalid(fldPath, color, "not defined by any RGBColorSet"))
+	}
+	check(specPath.Child("color"), r.Spec.Color)
+	for i, entry := range r.Spec.Palette {
+		check(specPath.Child("palette").Index(i).Child("color"), entry.Color)
+	}
+	return allErrs
+}
+
+// validatePolicy checks the RGB resource against the RGBPolicies selecting
+// its namespace. On update it is only checked when the count or the images
+// change, so tightening a policy does not lock existing RGB resources.
+func (r *RGBResourceManager) validatePolicy(old *RGBResourceManager) field.ErrorList {
+	images := r.Images()
+	if old != nil && old.Spec.Count == r.Spec.Count && reflect.DeepEqual(old.Images(), images) {
+		return nil
+	}
+	if webhookClient == nil {
+		limits := &RGBLimits{MinCount: DefaultMinCount, MaxCount: DefaultMaxCount}
+		return limits.Check(r, images, 0)
+	}
+
+	ctx := context.Background()
+	limits, err := LimitsFor(ctx, webhookClient, r.ChildNamespace())
+	if apierrors.IsNotFound(err) && r.Spec.TargetNamespace != "" {
+		return field.ErrorList{field.NotFound(field.NewPath("spec", "targetNamespace"), r.Spec.TargetNamespace)}
+	}
+	if err != nil {
+		return field.ErrorList{field.InternalError(field.NewPath("spec"), err)}
+	}
+	others := int32(0)
+	if limits.MaxChildren != nil {
+		if others, err = NamespaceCount(ctx, webhookClient, r.ChildNamespace(), client.ObjectKeyFromObject(r)); err != nil {
+			return field.ErrorList{field.InternalError(field.NewPath("spec"), err)}
+		}
+	}
+	return limits.Check(r, images, others)
+}
+
+// colorsInUse returns spec.color and the colors of the palette.
+func (r *RGBResourceManager) colorsInUse() map[RGBColor]bool {
+	colors := map[RGBColor]bool{r.Spec.Color: true}
+	for _, entry := range r.Spec.Palette {
+		colors[entry.Color] = true
+	}
+	return colors
+}
+
+// validatePalette checks that every color appears once and the counts fit
+// into spec.count. The scale subresource can still lower the count, the
+// controller then satisfies the counts in order. On update it is only
+// checked when the count or the palette change.
+func (r *RGBResourceManager) validatePalette(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	seen := map[RGBColor]bool{}
+	counted := int32(0)
+	for i, entry := range r.Spec.Palette {
+		entryPath := fldPath.Index(i)
+		if seen[entry.Color] {
+			allErrs = append(allErrs, field.Duplicate(entryPath.Child("color"), entry.Color))
+		}
+		seen[entry.Color] = true
+		if entry.Count != nil {
+			if entry.Weight != nil {
+				allErrs = append(allErrs, field.Forbidden(entryPath.Child("weight"), "can not be combined with count"))
+			}
+			counted += *entry.Count
+		}
+	}
+	if counted > r.Spec.Count {
+		allErrs = append(allErrs, field.Invalid(fldPath, counted,
+			fmt.Sprintf("counts add up to more than spec.count (%d)", r.Spec.Count)))
+	}
+	return allErrs
+}
+
+// validateManifest checks the parts of the generic mode that do not need
+// the cluster: the manifest has to agree with group, version and kind and
+// leave naming to the controller.
+func (r *RGBResourceManager) validateManifest(specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	manifestPath := specPath.Child("manifest")
+
+	if r.Spec.Group == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("group"), "required with a manifest"))
+	}
+	if r.Spec.Version == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("version"), "required with a manifest"))
+	}
+	if r.Spec.Template != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("template"), "can not be combined with a manifest"))
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(r.Spec.Manifest.Raw, &obj); err != nil || obj == nil {
+		return append(allErrs, field.Invalid(manifestPath, string(r.Spec.Manifest.Raw), "must be an object"))
+	}
+	manifest := unstructured.Unstructured{Object: obj}
+	gvk := r.ChildGroupVersionKind()
+	if apiVersion := manifest.GetAPIVersion(); apiVersion != "" && apiVersion != gvk.GroupVersion().String() {
+		allErrs = append(allErrs, field.Invalid(manifestPath.Child("apiVersion"), apiVersion,
+			fmt.Sprintf("must match group and version %q", gvk.GroupVersion())))
+	}
+	if kind := manifest.GetKind(); kind != "" && kind != gvk.Kind {
+		allErrs = append(allErrs, field.Invalid(manifestPath.Child("kind"), kind,
+			fmt.Sprintf("must match kind %q", gvk.Kind)))
+	}
+	metadataPath := manifestPath.Child("metadata")
+	if manifest.GetName() != "" || manifest.GetGenerateName() != "" {
+		allErrs = append(allErrs, field.Forbidden(metadataPath.Child("name"), "children are named by the controller"))
+	}
+	if manifest.GetNamespace() != "" {
+		allErrs = append(allErrs, field.Forbidden(metadataPath.Child("namespace"), "use spec.targetNamespace instead"))
+	}
+	return allErrs
+}
+
+// validateTemplate catches the template mistakes that would otherwise only
+// show up as failed child creates. The API server validates the rest.
+func validateTemplate(template *corev1.PodTemplateSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	containersPath := fldPath.Child("spec", "containers")
+	if len(template.Spec.Containers) == 0 {
+		return append(allErrs, field.Required(containersPath, "at least one container is required"))
+	}
+	for i, c := range template.Spec.Containers {
+		if c.Name == "" {
+			allErrs = append(allErrs, field.Required(containersPath.Index(i).Child("name"), ""))
+		}
+		if c.Image == "" {
+			allErrs = append(allErrs, field.Required(containersPath.Index(i).Child("image"), ""))
+		}
+	}
+	return allErrs
+}
+
+// toInvalid wraps field errors into the Invalid status error the API server
+// hands back to the user, or returns nil when there are none.
+func (r *RGBResourceManager) toInvalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "RGBResourceManager"},
+		r.Name, allErrs)
+}
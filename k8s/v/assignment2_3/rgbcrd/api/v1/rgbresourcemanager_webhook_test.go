@@ -0,0 +1,186 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// causeFields returns the field paths an Invalid error from the webhook names.
+func causeFields(err error) []string {
+	var fields []string
+	if status, ok := err.(apierrors.APIStatus); ok && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			fields = append(fields, cause.Field)
+		}
+	}
+	return fields
+}
+
+var _ = Describe("RGBResourceManager webhook", func() {
+	newRGB := func(name string) *RGBResourceManager {
+		return &RGBResourceManager{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: RGBResourceManagerSpec{
+				Kind:  RGBSupportedKind(PodRc),
+				Count: 2,
+			},
+		}
+	}
+
+	Context("on create", func() {
+		It("defaults the group from the kind", func() {
+			rgb := newRGB("rgb-defaulted")
+			Expect(k8sClient.Create(ctx, rgb)).To(Succeed())
+			Expect(rgb.Spec.Group).To(Equal(RGBSupportedGroup(CoreGrp)))
+			Expect(k8sClient.Delete(ctx, rgb)).To(Succeed())
+		})
+
+		It("rejects a group the kind does not belong to", func() {
+			rgb := newRGB("rgb-bad-group")
+			rgb.Spec.Group = RGBSupportedGroup(AppsGrp)
+			err := k8sClient.Create(ctx, rgb)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "%v", err)
+			Expect(causeFields(err)).To(ConsistOf("spec.group"))
+		})
+
+		It("rejects a version the kind is not served as", func() {
+			rgb := newRGB("rgb-bad-version")
+			rgb.Spec.Version = "v2"
+			err := k8sClient.Create(ctx, rgb)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "%v", err)
+			Expect(causeFields(err)).To(ContainElement("spec.version"))
+		})
+
+		It("rejects an unsupported kind", func() {
+			rgb := newRGB("rgb-bad-kind")
+			rgb.Spec.Kind = "CronJob"
+			err := k8sClient.Create(ctx, rgb)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "%v", err)
+			Expect(causeFields(err)).To(ContainElement("spec.kind"))
+		})
+
+		It("rejects a target namespace that does not exist", func() {
+			rgb := newRGB("rgb-missing-target")
+			rgb.Spec.TargetNamespace = "no-such-namespace"
+			err := k8sClient.Create(ctx, rgb)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "%v", err)
+			Expect(causeFields(err)).To(ConsistOf("spec.targetNamespace"))
+		})
+	})
+
+	Context("on update", func() {
+		var rgb *RGBResourceManager
+
+		BeforeEach(func() {
+			rgb = newRGB("rgb-immutable")
+			Expect(k8sClient.Create(ctx, rgb)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.Delete(ctx, rgb)).To(Succeed())
+		})
+
+		It("rejects switching the kind", func() {
+			updated := rgb.DeepCopy()
+			updated.Spec.Kind = RGBSupportedKind(DeploymentRc)
+			updated.Spec.Group = RGBSupportedGroup(AppsGrp)
+			err := k8sClient.Update(ctx, updated)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "%v", err)
+			Expect(causeFields(err)).To(ConsistOf("spec.group", "spec.kind"))
+		})
+
+		It("rejects moving the children to another namespace", func() {
+			updated := rgb.DeepCopy()
+			updated.Spec.TargetNamespace = "kube-system"
+			err := k8sClient.Update(ctx, updated)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "%v", err)
+			Expect(causeFields(err)).To(ContainElement("spec.targetNamespace"))
+		})
+
+		It("accepts a new count and color", func() {
+			updated := rgb.DeepCopy()
+			updated.Spec.Count = 3
+			updated.Spec.Color = GreenColor
+			Expect(k8sClient.Update(ctx, updated)).To(Succeed())
+		})
+	})
+})
+
+func TestValidateUpdateAfterScale(t *testing.T) {
+	// Scaled to 1 through the scale subresource, below the palette's 3.
+	scaled := &RGBResourceManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "rgb", Namespace: "default"},
+		Spec: RGBResourceManagerSpec{
+			Group:   RGBSupportedGroup(CoreGrp),
+			Version: RGBSupportedVersion(VerV1),
+			Kind:    RGBSupportedKind(PodRc),
+			Count:   1,
+			Color:   RedColor,
+			Palette: []RGBPaletteEntry{{Color: RedColor, Count: int32Ptr(3)}},
+		},
+	}
+	now := metav1.Now()
+	tests := []struct {
+		name    string
+		update  func(rgb *RGBResourceManager)
+		wantErr bool
+	}{
+		{
+			name:   "finalizer added",
+			update: func(rgb *RGBResourceManager) { rgb.Finalizers = []string{"kd.kb.example.com/cleanup"} },
+		},
+		{
+			name: "being deleted",
+			update: func(rgb *RGBResourceManager) {
+				rgb.DeletionTimestamp = &now
+				rgb.Finalizers = nil
+				rgb.Spec.Paused = true
+			},
+		},
+		{
+			name:   "spec changed elsewhere",
+			update: func(rgb *RGBResourceManager) { rgb.Spec.Paused = true },
+		},
+		{
+			name:    "palette changed",
+			update:  func(rgb *RGBResourceManager) { rgb.Spec.Palette[0].Count = int32Ptr(2) },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := scaled.DeepCopy()
+			tt.update(updated)
+			err := updated.ValidateUpdate(scaled)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateUpdate() = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if fields := causeFields(err); len(fields) != 1 || fields[0] != "spec.palette" {
+					t.Errorf("ValidateUpdate() fields = %v, want [spec.palette]", fields)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// childSelector returns the selector the children of the RGB resource are
+// claimed by: spec.selector, or the labels every child carries.
+func childSelector(rgb_resource *kdv1.RGBResourceManager) (labels.Selector, error) {
+	if rgb_resource.Spec.Selector == nil {
+		return labels.SelectorFromSet(selectorLabels(rgb_resource)), nil
+	}
+	return metav1.LabelSelectorAsSelector(rgb_resource.Spec.Selector)
+}
+
+// claimChildren releases the children that no longer match selector and
+// adopts the objects of the kind that match it and have no controller, the
+// way the ReplicaSet controller claims pods. It returns the children owned
+// afterwards. Children in another namespace carry no owner reference and are
+// returned as they are.
+func (r *RGBResourceManagerReconciler) claimChildren(ctx context.Context, log logr.Logger, m childManager, rgb_resource *kdv1.RGBResourceManager, selector labels.Selector, owned []client.Object, events *childEvents) ([]client.Object, error) {
+	if isCrossNamespace(rgb_resource) {
+		return owned, nil
+	}
+	op := m.op()
+	kind := string(rgb_resource.Spec.Kind)
+
+	claimed := make([]client.Object, 0, len(owned))
+	for _, child := range owned {
+		if child.GetDeletionTimestamp() != nil || selector.Matches(labels.Set(child.GetLabels())) {
+			claimed = append(claimed, child)
+			continue
+		}
+		log.Info("Reconciling RGB", "operation", "release-"+op, "Name", child.GetName())
+		err := client.IgnoreNotFound(r.release(ctx, rgb_resource, child))
+		countOperation(kind, "release", err)
+		if err != nil {
+			log.Info("Reconciling RGB", "operation", "release-"+op, "Failed", child.GetName())
+			return nil, err
+		}
+		events.add(eventReleased, child.GetName())
+	}
+
+	orphans, err := m.list(ctx, r, client.InNamespace(rgb_resource.Namespace), client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return nil, err
+	}
+	checked := false
+	for _, orphan := range orphans {
+		// Finished children would only be replaced, leave them be.
+		if !isOrphan(orphan, rgb_resource) || orphan.GetDeletionTimestamp() != nil || m.isTerminal(orphan) {
+			continue
+		}
+		if !checked {
+			if err := r.canAdopt(ctx, rgb_resource); err != nil {
+				return nil, err
+			}
+			checked = true
+		}
+		log.Info("Reconciling RGB", "operation", "adopt-"+op, "Name", orphan.GetName())
+		err := r.adopt(ctx, rgb_resource, orphan)
+		countOperation(kind, "adopt", client.IgnoreNotFound(err))
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			log.Info("Reconciling RGB", "operation", "adopt-"+op, "Failed", orphan.GetName())
+			return nil, err
+		}
+		events.add(eventAdopted, orphan.GetName())
+		claimed = append(claimed, orphan)
+	}
+	return claimed, nil
+}
+
+// canAdopt rereads the RGB resource from the API server before the first
+// adoption of a pass. The cached copy may miss that it is being deleted, or
+// that it was replaced by another of the same name.
+func (r *RGBResourceManagerReconciler) canAdopt(ctx context.Context, rgb_resource *kdv1.RGBResourceManager) error {
+	var fresh kdv1.RGBResourceManager
+	if err := r.apiReader.Get(ctx, client.ObjectKeyFromObject(rgb_resource), &fresh); err != nil {
+		return err
+	}
+	if fresh.UID != rgb_resource.UID {
+		return fmt.Errorf("original %s/%s is gone: got uid %v, wanted %v", fresh.Namespace, fresh.Name, fresh.UID, rgb_resource.UID)
+	}
+	if fresh.DeletionTimestamp != nil {
+		return fmt.Errorf("%s/%s has just been deleted at %v", fresh.Namespace, fresh.Name, fresh.DeletionTimestamp)
+	}
+	return nil
+}
+
+// isOrphan reports whether the RGB resource may adopt obj: no one controls
+// it, and no RGB resource of another namespace placed it there. Those tie
+// their children by the owner UID annotation rather than a controller
+// reference, and the default selector does not tell the namespaces apart.
+func isOrphan(obj client.Object, rgb_resource *kdv1.RGBResourceManager) bool {
+	if metav1.GetControllerOf(obj) != nil {
+		return false
+	}
+	if _, ok := obj.GetAnnotations()[ownerUIDAnnotation]; ok {
+		return false
+	}
+	namespace, ok := obj.GetLabels()[rgbNamespaceLabel]
+	return !ok || namespace == rgb_resource.Namespace
+}
+
+// isAdopted reports whether child was adopted rather than created by the
+// RGB resource, see adopt.
+func isAdopted(child client.Object) bool {
+	hash, ok := child.GetAnnotations()[templateHashAnnotation]
+	return ok && hash == ""
+}
+
+// adopt makes the RGB resource the controller of obj and labels it like the
+// children it creates. The patch fails if obj changed since it was read, so
+// a controller that got there first is never overwritten. The empty template
+// hash matches no template, so the adopted object is replaced by a child of
+// the RGB resource's own making rather than forced into its shape.
+func (r *RGBResourceManagerReconciler) adopt(ctx context.Context, rgb_resource *kdv1.RGBResourceManager, obj client.Object) error {
+	patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+	if err := ctrl.SetControllerReference(rgb_resource, obj, r.Scheme); err != nil {
+		return err
+	}
+	obj.SetLabels(mergeLabels(obj.GetLabels(), map[string]string{
+		rgbNameLabel:      rgb_resource.Name,
+		rgbNamespaceLabel: rgb_resource.Namespace,
+	}))
+	obj.SetAnnotations(mergeLabels(obj.GetAnnotations(), map[string]string{
+		templateHashAnnotation: "",
+	}))
+	return r.Patch(ctx, obj, patch, client.FieldOwner(fieldManager))
+}
+
+// release removes the owner reference to the RGB resource from obj, which is
+// left running as it is.
+func (r *RGBResourceManagerReconciler) release(ctx context.Context, rgb_resource *kdv1.RGBResourceManager, obj client.Object) error {
+	patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+	var refs []metav1.OwnerReference
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID != rgb_resource.UID {
+			refs = append(refs, ref)
+		}
+	}
+	obj.SetOwnerReferences(refs)
+	return r.Patch(ctx, obj, patch, client.FieldOwner(fieldManager))
+}
+
+// mapOrphan enqueues the RGB resources of the namespace whose selector
+// matches an object no one controls, so they get to adopt it.
+func (r *RGBResourceManagerReconciler) mapOrphan(obj client.Object) []reconcile.Request {
+	if metav1.GetControllerOf(obj) != nil {
+		return nil
+	}
+	var rgbs kdv1.RGBResourceManagerList
+	if err := r.List(context.Background(), &rgbs, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list RGB resources", "Orphan", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range rgbs.Items {
+		rgb := &rgbs.Items[i]
+		if isCrossNamespace(rgb) || !isOrphan(obj, rgb) {
+			continue
+		}
+		selector, err := childSelector(rgb)
+		if err != nil || !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(rgb)})
+	}
+	return requests
+}
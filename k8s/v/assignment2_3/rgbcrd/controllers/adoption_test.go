@@ -0,0 +1,256 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// testRGB returns a RGB resource managing two Pods in the default namespace.
+func testRGB() *kdv1.RGBResourceManager {
+	return &kdv1.RGBResourceManager{
+		ObjectMeta: metav1.ObjectMeta{Name: "rgb", Namespace: "default", UID: "rgb-uid"},
+		Spec: kdv1.RGBResourceManagerSpec{
+			Group:   kdv1.RGBSupportedGroup(kdv1.CoreGrp),
+			Version: kdv1.RGBSupportedVersion(kdv1.VerV1),
+			Kind:    kdv1.RGBSupportedKind(kdv1.PodRc),
+			Count:   2,
+		},
+	}
+}
+
+// newTestReconciler returns a reconciler on a fake client holding objs.
+func newTestReconciler(objs ...client.Object) *RGBResourceManagerReconciler {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = kdv1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return &RGBResourceManagerReconciler{
+		Client:       c,
+		Scheme:       scheme,
+		Log:          logr.Discard(),
+		Recorder:     record.NewFakeRecorder(100),
+		apiReader:    c,
+		expectations: newExpectations(),
+	}
+}
+
+// matchingPod returns a Pod in the default namespace carrying the labels
+// the default selector of testRGB matches.
+func matchingPod(name string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "default",
+		Labels:    map[string]string{"app": "rgb", rgbNameLabel: "rgb"},
+	}}
+}
+
+func TestClaimChildren(t *testing.T) {
+	controller := true
+	tests := []struct {
+		name           string
+		pod            func(rgb *kdv1.RGBResourceManager, scheme *runtime.Scheme) *corev1.Pod
+		owned          bool
+		wantClaimed    bool
+		wantControlled bool
+	}{
+		{
+			name: "owned and matching",
+			pod: func(rgb *kdv1.RGBResourceManager, scheme *runtime.Scheme) *corev1.Pod {
+				pod := matchingPod("owned")
+				_ = ctrl.SetControllerReference(rgb, pod, scheme)
+				return pod
+			},
+			owned:          true,
+			wantClaimed:    true,
+			wantControlled: true,
+		},
+		{
+			name: "owned but no longer matching is released",
+			pod: func(rgb *kdv1.RGBResourceManager, scheme *runtime.Scheme) *corev1.Pod {
+				pod := matchingPod("relabeled")
+				pod.Labels["app"] = "other"
+				_ = ctrl.SetControllerReference(rgb, pod, scheme)
+				return pod
+			},
+			owned: true,
+		},
+		{
+			name: "matching orphan is adopted",
+			pod: func(*kdv1.RGBResourceManager, *runtime.Scheme) *corev1.Pod {
+				return matchingPod("orphan")
+			},
+			wantClaimed:    true,
+			wantControlled: true,
+		},
+		{
+			name: "controlled by something else",
+			pod: func(*kdv1.RGBResourceManager, *runtime.Scheme) *corev1.Pod {
+				pod := matchingPod("replicaset-pod")
+				pod.OwnerReferences = []metav1.OwnerReference{{
+					APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs", UID: "rs-uid", Controller: &controller,
+				}}
+				return pod
+			},
+		},
+		{
+			name: "child of a RGB resource in another namespace",
+			pod: func(*kdv1.RGBResourceManager, *runtime.Scheme) *corev1.Pod {
+				pod := matchingPod("cross-namespace")
+				pod.Annotations = map[string]string{ownerUIDAnnotation: "other-uid"}
+				return pod
+			},
+		},
+		{
+			name: "labeled for a RGB resource of another namespace",
+			pod: func(*kdv1.RGBResourceManager, *runtime.Scheme) *corev1.Pod {
+				pod := matchingPod("other-namespace")
+				pod.Labels[rgbNamespaceLabel] = "elsewhere"
+				return pod
+			},
+		},
+		{
+			name: "finished orphan",
+			pod: func(*kdv1.RGBResourceManager, *runtime.Scheme) *corev1.Pod {
+				pod := matchingPod("finished")
+				pod.Status.Phase = corev1.PodSucceeded
+				return pod
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			rgb := testRGB()
+			r := newTestReconciler(rgb)
+			pod := tt.pod(rgb, r.Scheme)
+			if err := r.Create(ctx, pod); err != nil {
+				t.Fatal(err)
+			}
+			var owned []client.Object
+			if tt.owned {
+				owned = append(owned, pod)
+			}
+			selector, _ := childSelector(rgb)
+
+			claimed, err := r.claimChildren(ctx, logr.Discard(), podManager{}, rgb, selector, owned, &childEvents{})
+			if err != nil {
+				t.Fatalf("claimChildren() error = %v", err)
+			}
+			if got := len(claimed) == 1; got != tt.wantClaimed {
+				t.Errorf("claimed %d children, want claimed %v", len(claimed), tt.wantClaimed)
+			}
+			var stored corev1.Pod
+			if err := r.Get(ctx, client.ObjectKeyFromObject(pod), &stored); err != nil {
+				t.Fatal(err)
+			}
+			if got := metav1.IsControlledBy(&stored, rgb); got != tt.wantControlled {
+				t.Errorf("controlled by the RGB resource = %v, want %v", got, tt.wantControlled)
+			}
+		})
+	}
+}
+
+func TestAdoptedChildIsStale(t *testing.T) {
+	ctx := context.Background()
+	rgb := testRGB()
+	r := newTestReconciler(rgb)
+	orphan := matchingPod("hand-made")
+	orphan.Spec.Containers = []corev1.Container{{Name: "app", Image: "busybox"}}
+	if err := r.Create(ctx, orphan); err != nil {
+		t.Fatal(err)
+	}
+	selector, _ := childSelector(rgb)
+
+	claimed, err := r.claimChildren(ctx, logr.Discard(), podManager{}, rgb, selector, nil, &childEvents{})
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("claimChildren() = %d children, %v, want the orphan", len(claimed), err)
+	}
+	adopted := claimed[0]
+	if adopted.GetLabels()[rgbNamespaceLabel] != "default" {
+		t.Errorf("adopted labels = %v, want %s=default", adopted.GetLabels(), rgbNamespaceLabel)
+	}
+	if !isAdopted(adopted) {
+		t.Errorf("isAdopted() = false, want true")
+	}
+	// Without spec.template, children without a hash are current; an
+	// adopted one must not be, drift repair would force the default Pod
+	// onto it.
+	template := podTemplate(rgb)
+	if isTemplateCurrent(adopted, rgb, templateHash(template)) {
+		t.Errorf("isTemplateCurrent() = true for an adopted child, want false")
+	}
+}
+
+func TestMapOrphan(t *testing.T) {
+	tests := []struct {
+		name string
+		pod  func() *corev1.Pod
+		want int
+	}{
+		{
+			name: "matching orphan",
+			pod:  func() *corev1.Pod { return matchingPod("orphan") },
+			want: 1,
+		},
+		{
+			name: "child of a RGB resource in another namespace",
+			pod: func() *corev1.Pod {
+				pod := matchingPod("cross-namespace")
+				pod.Annotations = map[string]string{ownerUIDAnnotation: "other-uid"}
+				return pod
+			},
+		},
+		{
+			name: "labeled for a RGB resource of another namespace",
+			pod: func() *corev1.Pod {
+				pod := matchingPod("other-namespace")
+				pod.Labels[rgbNamespaceLabel] = "elsewhere"
+				return pod
+			},
+		},
+		{
+			name: "not matching",
+			pod: func() *corev1.Pod {
+				pod := matchingPod("unrelated")
+				pod.Labels["app"] = "other"
+				return pod
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(testRGB())
+			if got := r.mapOrphan(tt.pod()); len(got) != tt.want {
+				t.Errorf("mapOrphan() = %v, want %d request(s)", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,558 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// childManager manages the children of one kind. The reconciler only talks
+// to children through it, so supporting another kind means adding a manager
+// to childManagers.
+type childManager interface {
+	// kind is the RGBSupportedKind handled by the manager.
+	kind() kdv1.RGBSupportedKind
+	// op names the kind in log and condition messages.
+	op() string
+	// object returns an empty object of the kind, for watches and indexes.
+	object() client.Object
+	// indexed reports whether children of the kind are indexed by ownerKey.
+	// Kinds only known at runtime are not, their informers start too late.
+	indexed() bool
+
+	// list returns the children matching opts.
+	list(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]client.Object, error)
+	// build returns a new child carrying labels, whose pods run template.
+	build(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) client.Object
+	// create creates a new child under fieldManager. It fails when an
+	// object of the name already exists rather than taking it over.
+	create(ctx context.Context, c client.Client, obj client.Object) error
+	delete(ctx context.Context, c client.Writer, obj client.Object) error
+
+	// isReady reports whether the child is serving.
+	isReady(obj client.Object) bool
+	// isTerminal reports whether the child stopped for good and has to be
+	// replaced.
+	isTerminal(obj client.Object) bool
+	// isUpToDate reports whether the child and the pods it runs carry color.
+	isUpToDate(obj client.Object, color string) bool
+
+	// recolor sets color on the child and reports whether that changed it.
+	// Kinds whose pods would not follow leave the child alone, it is
+	// replaced instead.
+	recolor(obj client.Object, color string) bool
+	// retemplate switches the child to template and reports whether that
+	// changed it. Kinds that can not update their pods return false, the
+	// child is replaced instead.
+	retemplate(obj client.Object, template corev1.PodTemplateSpec) bool
+}
+
+// childManagers lists every kind a RGB resource can manage. The webhook's
+// supportedKindGroups has to agree with it.
+var childManagers = []childManager{
+	podManager{},
+	deploymentManager{},
+	statefulSetManager{},
+	daemonSetManager{},
+	replicaSetManager{},
+	jobManager{},
+}
+
+// childManagerFor returns the manager of kind, or nil if kind is unsupported.
+func childManagerFor(kind kdv1.RGBSupportedKind) childManager {
+	for _, m := range childManagers {
+		if m.kind() == kind {
+			return m
+		}
+	}
+	return nil
+}
+
+// ownedTypes are the child kinds indexed by ownerKey and watched through Owns.
+func ownedTypes() []client.Object {
+	objs := make([]client.Object, 0, len(childManagers))
+	for _, m := range childManagers {
+		objs = append(objs, m.object())
+	}
+	return objs
+}
+
+// baseManager holds the parts of childManager that are the same for every kind.
+type baseManager struct{}
+
+func (baseManager) create(ctx context.Context, c client.Client, obj client.Object) error {
+	return c.Create(ctx, obj, client.FieldOwner(fieldManager))
+}
+
+// delete takes the pods of the child along, batch/v1 Jobs would otherwise
+// orphan theirs.
+func (baseManager) delete(ctx context.Context, c client.Writer, obj client.Object) error {
+	return c.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground))
+}
+
+func (baseManager) indexed() bool {
+	return true
+}
+
+func (baseManager) isTerminal(obj client.Object) bool {
+	return false
+}
+
+func (baseManager) retemplate(obj client.Object, template corev1.PodTemplateSpec) bool {
+	return false
+}
+
+// podManager manages bare Pods. Their labels can change, their spec can not.
+type podManager struct{ baseManager }
+
+func (podManager) kind() kdv1.RGBSupportedKind { return kdv1.RGBSupportedKind(kdv1.PodRc) }
+func (podManager) op() string                  { return "pod" }
+func (podManager) object() client.Object       { return &corev1.Pod{} }
+
+func (podManager) list(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]client.Object, error) {
+	var childPods corev1.PodList
+	if err := c.List(ctx, &childPods, opts...); err != nil {
+		return nil, err
+	}
+	children := make([]client.Object, 0, len(childPods.Items))
+	for i := range childPods.Items {
+		children = append(children, &childPods.Items[i])
+	}
+	return children, nil
+}
+
+func (podManager) build(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) client.Object {
+	return createPodObj(namespace, name, labels, template)
+}
+
+func (podManager) isReady(obj client.Object) bool {
+	return isPodReady(obj.(*corev1.Pod))
+}
+
+// isTerminal is true for a finished pod, which never serves again.
+func (podManager) isTerminal(obj client.Object) bool {
+	return isPodTerminal(obj.(*corev1.Pod))
+}
+
+func (podManager) isUpToDate(obj client.Object, color string) bool {
+	return obj.GetLabels()[colorLabel] == color
+}
+
+func (podManager) recolor(obj client.Object, color string) bool {
+	return setLabel(&obj.(*corev1.Pod).ObjectMeta, colorLabel, color)
+}
+
+// deploymentManager manages Deployments running a single replica each.
+type deploymentManager struct{ baseManager }
+
+func (deploymentManager) kind() kdv1.RGBSupportedKind {
+	return kdv1.RGBSupportedKind(kdv1.DeploymentRc)
+}
+func (deploymentManager) op() string            { return "deployment" }
+func (deploymentManager) object() client.Object { return &appsv1.Deployment{} }
+
+func (deploymentManager) list(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]client.Object, error) {
+	var childDeployments appsv1.DeploymentList
+	if err := c.List(ctx, &childDeployments, opts...); err != nil {
+		return nil, err
+	}
+	children := make([]client.Object, 0, len(childDeployments.Items))
+	for i := range childDeployments.Items {
+		children = append(children, &childDeployments.Items[i])
+	}
+	return children, nil
+}
+
+func (deploymentManager) build(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) client.Object {
+	return createDeploymentObj(namespace, name, 1, labels, template)
+}
+
+func (deploymentManager) isReady(obj client.Object) bool {
+	return isDeploymentAvailable(obj.(*appsv1.Deployment))
+}
+
+func (deploymentManager) isUpToDate(obj client.Object, color string) bool {
+	d := obj.(*appsv1.Deployment)
+	return d.Labels[colorLabel] == color &&
+		d.Spec.Template.Labels[colorLabel] == color &&
+		isDeploymentRolledOut(d)
+}
+
+// recolor also changes the pod template labels, which rolls out new pods.
+func (deploymentManager) recolor(obj client.Object, color string) bool {
+	d := obj.(*appsv1.Deployment)
+	return recolorWorkload(&d.ObjectMeta, &d.Spec.Template, color)
+}
+
+func (deploymentManager) retemplate(obj client.Object, template corev1.PodTemplateSpec) bool {
+	d := obj.(*appsv1.Deployment)
+	return retemplateWorkload(&d.ObjectMeta, &d.Spec.Template, d.Spec.Selector, template)
+}
+
+// statefulSetManager manages StatefulSets running a single replica each.
+type statefulSetManager struct{ baseManager }
+
+func (statefulSetManager) kind() kdv1.RGBSupportedKind {
+	return kdv1.RGBSupportedKind(kdv1.StatefulSetRc)
+}
+func (statefulSetManager) op() string            { return "statefulset" }
+func (statefulSetManager) object() client.Object { return &appsv1.StatefulSet{} }
+
+func (statefulSetManager) list(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]client.Object, error) {
+	var childSets appsv1.StatefulSetList
+	if err := c.List(ctx, &childSets, opts...); err != nil {
+		return nil, err
+	}
+	children := make([]client.Object, 0, len(childSets.Items))
+	for i := range childSets.Items {
+		children = append(children, &childSets.Items[i])
+	}
+	return children, nil
+}
+
+func (statefulSetManager) build(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) client.Object {
+	replicas := int32(1)
+	selector := workloadSelector(name, labels)
+	return &appsv1.StatefulSet{
+		ObjectMeta: childMeta(namespace, name, labels, template),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: podTemplateWith(template, mergeLabels(labels, selector)),
+			// No governing service is created, the name only has to be set.
+			ServiceName: name,
+		},
+	}
+}
+
+func (statefulSetManager) isReady(obj client.Object) bool {
+	s := obj.(*appsv1.StatefulSet)
+	return s.Status.ObservedGeneration >= s.Generation && s.Status.ReadyReplicas >= replicasOf(s.Spec.Replicas)
+}
+
+func (statefulSetManager) isUpToDate(obj client.Object, color string) bool {
+	s := obj.(*appsv1.StatefulSet)
+	return s.Labels[colorLabel] == color &&
+		s.Spec.Template.Labels[colorLabel] == color &&
+		s.Status.ObservedGeneration >= s.Generation &&
+		s.Status.UpdatedReplicas == replicasOf(s.Spec.Replicas) &&
+		s.Status.CurrentRevision == s.Status.UpdateRevision
+}
+
+func (statefulSetManager) recolor(obj client.Object, color string) bool {
+	s := obj.(*appsv1.StatefulSet)
+	return recolorWorkload(&s.ObjectMeta, &s.Spec.Template, color)
+}
+
+func (statefulSetManager) retemplate(obj client.Object, template corev1.PodTemplateSpec) bool {
+	s := obj.(*appsv1.StatefulSet)
+	return retemplateWorkload(&s.ObjectMeta, &s.Spec.Template, s.Spec.Selector, template)
+}
+
+// daemonSetManager manages DaemonSets. Count is the number of DaemonSets,
+// each of them runs a pod on every eligible node.
+type daemonSetManager struct{ baseManager }
+
+func (daemonSetManager) kind() kdv1.RGBSupportedKind {
+	return kdv1.RGBSupportedKind(kdv1.DaemonSetRc)
+}
+func (daemonSetManager) op() string            { return "daemonset" }
+func (daemonSetManager) object() client.Object { return &appsv1.DaemonSet{} }
+
+func (daemonSetManager) list(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]client.Object, error) {
+	var childSets appsv1.DaemonSetList
+	if err := c.List(ctx, &childSets, opts...); err != nil {
+		return nil, err
+	}
+	children := make([]client.Object, 0, len(childSets.Items))
+	for i := range childSets.Items {
+		children = append(children, &childSets.Items[i])
+	}
+	return children, nil
+}
+
+func (daemonSetManager) build(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) client.Object {
+	selector := workloadSelector(name, labels)
+	return &appsv1.DaemonSet{
+		ObjectMeta: childMeta(namespace, name, labels, template),
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: podTemplateWith(template, mergeLabels(labels, selector)),
+		},
+	}
+}
+
+// isReady is true once every scheduled pod is available. A DaemonSet no
+// node is eligible for has nothing to wait for.
+func (daemonSetManager) isReady(obj client.Object) bool {
+	s := obj.(*appsv1.DaemonSet)
+	return s.Status.ObservedGeneration >= s.Generation &&
+		s.Status.NumberUnavailable == 0 &&
+		s.Status.NumberAvailable >= s.Status.DesiredNumberScheduled
+}
+
+func (daemonSetManager) isUpToDate(obj client.Object, color string) bool {
+	s := obj.(*appsv1.DaemonSet)
+	return s.Labels[colorLabel] == color &&
+		s.Spec.Template.Labels[colorLabel] == color &&
+		s.Status.ObservedGeneration >= s.Generation &&
+		s.Status.UpdatedNumberScheduled == s.Status.DesiredNumberScheduled
+}
+
+func (daemonSetManager) recolor(obj client.Object, color string) bool {
+	s := obj.(*appsv1.DaemonSet)
+	return recolorWorkload(&s.ObjectMeta, &s.Spec.Template, color)
+}
+
+func (daemonSetManager) retemplate(obj client.Object, template corev1.PodTemplateSpec) bool {
+	s := obj.(*appsv1.DaemonSet)
+	return retemplateWorkload(&s.ObjectMeta, &s.Spec.Template, s.Spec.Selector, template)
+}
+
+// replicaSetManager manages ReplicaSets running a single replica each. A
+// ReplicaSet never touches the pods it already runs, so it is neither
+// recolored nor retemplated but replaced.
+type replicaSetManager struct{ baseManager }
+
+func (replicaSetManager) kind() kdv1.RGBSupportedKind {
+	return kdv1.RGBSupportedKind(kdv1.ReplicaSetRc)
+}
+func (replicaSetManager) op() string            { return "replicaset" }
+func (replicaSetManager) object() client.Object { return &appsv1.ReplicaSet{} }
+
+func (replicaSetManager) list(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]client.Object, error) {
+	var childSets appsv1.ReplicaSetList
+	if err := c.List(ctx, &childSets, opts...); err != nil {
+		return nil, err
+	}
+	children := make([]client.Object, 0, len(childSets.Items))
+	for i := range childSets.Items {
+		children = append(children, &childSets.Items[i])
+	}
+	return children, nil
+}
+
+func (replicaSetManager) build(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) client.Object {
+	replicas := int32(1)
+	selector := workloadSelector(name, labels)
+	return &appsv1.ReplicaSet{
+		ObjectMeta: childMeta(namespace, name, labels, template),
+		Spec: appsv1.ReplicaSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: podTemplateWith(template, mergeLabels(labels, selector)),
+		},
+	}
+}
+
+func (replicaSetManager) isReady(obj client.Object) bool {
+	s := obj.(*appsv1.ReplicaSet)
+	return s.Status.ObservedGeneration >= s.Generation && s.Status.AvailableReplicas >= replicasOf(s.Spec.Replicas)
+}
+
+func (replicaSetManager) isUpToDate(obj client.Object, color string) bool {
+	return obj.GetLabels()[colorLabel] == color
+}
+
+func (replicaSetManager) recolor(obj client.Object, color string) bool {
+	return false
+}
+
+// jobManager manages Jobs running a single pod to completion. A completed
+// Job counts as ready, a failed one is replaced. The pod template of a Job
+// can not change, so it is neither recolored nor retemplated but replaced.
+type jobManager struct{ baseManager }
+
+func (jobManager) kind() kdv1.RGBSupportedKind { return kdv1.RGBSupportedKind(kdv1.JobRc) }
+func (jobManager) op() string                  { return "job" }
+func (jobManager) object() client.Object       { return &batchv1.Job{} }
+
+func (jobManager) list(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]client.Object, error) {
+	var childJobs batchv1.JobList
+	if err := c.List(ctx, &childJobs, opts...); err != nil {
+		return nil, err
+	}
+	children := make([]client.Object, 0, len(childJobs.Items))
+	for i := range childJobs.Items {
+		children = append(children, &childJobs.Items[i])
+	}
+	return children, nil
+}
+
+// build leaves the selector to the Job controller, which generates one
+// unique to the Job. The pods still carry the child name.
+func (jobManager) build(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) client.Object {
+	podTemplate := podTemplateWith(template, mergeLabels(labels, map[string]string{childNameLabel: name}))
+	if podTemplate.Spec.RestartPolicy == "" || podTemplate.Spec.RestartPolicy == corev1.RestartPolicyAlways {
+		podTemplate.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+	}
+	return &batchv1.Job{
+		ObjectMeta: childMeta(namespace, name, labels, template),
+		Spec: batchv1.JobSpec{
+			Template: podTemplate,
+		},
+	}
+}
+
+func (jobManager) isReady(obj client.Object) bool {
+	return hasJobCondition(obj.(*batchv1.Job), batchv1.JobComplete)
+}
+
+func (jobManager) isTerminal(obj client.Object) bool {
+	return hasJobCondition(obj.(*batchv1.Job), batchv1.JobFailed)
+}
+
+func (jobManager) isUpToDate(obj client.Object, color string) bool {
+	return obj.GetLabels()[colorLabel] == color
+}
+
+func (jobManager) recolor(obj client.Object, color string) bool {
+	return false
+}
+
+// workloadSelector returns the pod selector of a child workload. Each child
+// selects only its own pods, siblings must not overlap. The color is left
+// out since it changes while a selector can not.
+func workloadSelector(name string, labels map[string]string) map[string]string {
+	selector := copyLabels(labels)
+	delete(selector, colorLabel)
+	selector[childNameLabel] = name
+	return selector
+}
+
+// childMeta returns the metadata of a new child built from template.
+func childMeta(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      name,
+		Labels:    copyLabels(labels),
+		Namespace: namespace,
+		Annotations: map[string]string{
+			templateHashAnnotation: templateHash(template),
+		},
+	}
+}
+
+// podTemplateWith returns template with podLabels set on top of its own.
+func podTemplateWith(template corev1.PodTemplateSpec, podLabels map[string]string) corev1.PodTemplateSpec {
+	template.Labels = mergeLabels(template.Labels, podLabels)
+	return template
+}
+
+// recolorWorkload sets color on a workload and its pod template.
+func recolorWorkload(meta *metav1.ObjectMeta, current *corev1.PodTemplateSpec, color string) bool {
+	changed := setLabel(meta, colorLabel, color)
+	return setLabel(&current.ObjectMeta, colorLabel, color) || changed
+}
+
+// retemplateWorkload replaces the pod template of a workload. The selector
+// can not change, so the pod labels it relies on and the color are kept.
+func retemplateWorkload(meta *metav1.ObjectMeta, current *corev1.PodTemplateSpec, selector *metav1.LabelSelector, template corev1.PodTemplateSpec) bool {
+	hash := templateHash(template)
+	color := current.Labels[colorLabel]
+	template.Labels = mergeLabels(template.Labels, selector.MatchLabels)
+	if color != "" {
+		template.Labels[colorLabel] = color
+	}
+	*current = template
+	return setAnnotation(meta, templateHashAnnotation, hash)
+}
+
+func createDeploymentObj(namespace string, name string, replicas int32, labels map[string]string, template corev1.PodTemplateSpec) *appsv1.Deployment {
+	selector := workloadSelector(name, labels)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: childMeta(namespace, name, labels, template),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selector,
+			},
+			Template: podTemplateWith(template, mergeLabels(labels, selector)),
+		},
+	}
+	return deployment
+}
+
+func createPodObj(namespace string, name string, podLabels map[string]string, template corev1.PodTemplateSpec) *corev1.Pod {
+	deployment := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Labels:    mergeLabels(template.Labels, podLabels),
+			Namespace: namespace,
+			Annotations: mergeLabels(template.Annotations, map[string]string{
+				templateHashAnnotation: templateHash(template),
+			}),
+		},
+		Spec: template.Spec,
+	}
+	return deployment
+}
+
+// replicasOf defaults an unset replica count the way the API server does.
+func replicasOf(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// isPodReady reports whether the pod's Ready condition is True.
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// isPodTerminal reports whether the pod reached a phase it never leaves.
+func isPodTerminal(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// isDeploymentAvailable reports whether the deployment controller has seen
+// the latest spec and all of its replicas are available.
+func isDeploymentAvailable(d *appsv1.Deployment) bool {
+	return d.Status.ObservedGeneration >= d.Generation && d.Status.AvailableReplicas >= replicasOf(d.Spec.Replicas)
+}
+
+// isDeploymentRolledOut reports whether every pod of the deployment runs
+// the latest pod template.
+func isDeploymentRolledOut(d *appsv1.Deployment) bool {
+	replicas := replicasOf(d.Spec.Replicas)
+	return d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas == replicas &&
+		d.Status.Replicas == replicas
+}
+
+// hasJobCondition reports whether the job's condition of type t is True.
+func hasJobCondition(job *batchv1.Job, t batchv1.JobConditionType) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == t {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+func TestReleaseChild(t *testing.T) {
+	controller := true
+	tests := []struct {
+		name       string
+		policy     kdv1.RGBDeletionPolicy
+		wantLabels map[string]string
+	}{
+		{
+			name:       "Orphan drops the RGB labels",
+			policy:     kdv1.DeletionPolicyOrphan,
+			wantLabels: map[string]string{"app": "rgb", colorLabel: "Red"},
+		},
+		{
+			name:   "Retain keeps them",
+			policy: kdv1.DeletionPolicyRetain,
+			wantLabels: map[string]string{
+				"app": "rgb", colorLabel: "Red", rgbNameLabel: "rgb", rgbNamespaceLabel: "default",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rgb := testRGB()
+			other := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "keep", UID: "keep-uid"}
+			child := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name: "child",
+				Labels: map[string]string{
+					"app": "rgb", colorLabel: "Red", rgbNameLabel: "rgb", rgbNamespaceLabel: "default",
+				},
+				Annotations: map[string]string{ownerUIDAnnotation: "rgb-uid", "note": "kept"},
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: apiGVStr, Kind: "RGBResourceManager", Name: "rgb", UID: rgb.UID, Controller: &controller},
+					other,
+				},
+			}}
+
+			releaseChild(child, rgb, tt.policy)
+			if got := child.OwnerReferences; !reflect.DeepEqual(got, []metav1.OwnerReference{other}) {
+				t.Errorf("owner references = %v, want only %v", got, other)
+			}
+			if got := child.Annotations; !reflect.DeepEqual(got, map[string]string{"note": "kept"}) {
+				t.Errorf("annotations = %v, want the owner UID gone", got)
+			}
+			if got := child.Labels; !reflect.DeepEqual(got, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", got, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestDeletionPolicyDefault(t *testing.T) {
+	rgb := testRGB()
+	if got := deletionPolicy(rgb); got != kdv1.DeletionPolicyDelete {
+		t.Errorf("deletionPolicy() = %s, want %s", got, kdv1.DeletionPolicyDelete)
+	}
+	rgb.Spec.DeletionPolicy = kdv1.DeletionPolicyRetain
+	if got := deletionPolicy(rgb); got != kdv1.DeletionPolicyRetain {
+		t.Errorf("deletionPolicy() = %s, want %s", got, kdv1.DeletionPolicyRetain)
+	}
+}
+
+// TestFinalizeRGB deletes a RGB resource whose children live in another
+// namespace, where the garbage collector would not reach them.
+func TestFinalizeRGB(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       kdv1.RGBDeletionPolicy
+		wantChildren int
+		wantLabeled  bool
+		// passes is how many reconciles it takes to drop the finalizer.
+		passes int
+	}{
+		{
+			name:   "Delete waits for the children to go",
+			policy: kdv1.DeletionPolicyDelete,
+			passes: 2,
+		},
+		{
+			name:         "Orphan releases the children",
+			policy:       kdv1.DeletionPolicyOrphan,
+			wantChildren: 2,
+			passes:       1,
+		},
+		{
+			name:         "Retain releases the children and keeps their labels",
+			policy:       kdv1.DeletionPolicyRetain,
+			wantChildren: 2,
+			wantLabeled:  true,
+			passes:       1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := metav1.Now()
+			rgb := testRGB()
+			rgb.Spec.TargetNamespace = "other"
+			rgb.Spec.DeletionPolicy = tt.policy
+			rgb.DeletionTimestamp = &now
+			controllerutil.AddFinalizer(rgb, cleanupFinalizer)
+			objs := []client.Object{rgb}
+			for _, name := range []string{"a", "b"} {
+				objs = append(objs, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   "other",
+					Labels:      childLabels(rgb),
+					Annotations: map[string]string{ownerUIDAnnotation: string(rgb.UID)},
+				}})
+			}
+			r := newTestReconciler(objs...)
+			key := types.NamespacedName{Namespace: "default", Name: "rgb"}
+
+			for pass := 1; pass <= tt.passes; pass++ {
+				var current kdv1.RGBResourceManager
+				if err := r.Get(ctx, key, &current); err != nil {
+					t.Fatal(err)
+				}
+				result, err := r.finalizeRGB(ctx, logr.Discard(), &current)
+				if err != nil {
+					t.Fatalf("pass %d: finalizeRGB() error = %v", pass, err)
+				}
+				done := !controllerutil.ContainsFinalizer(&current, cleanupFinalizer)
+				if done != (pass == tt.passes) {
+					t.Fatalf("pass %d: finalizer removed = %v, result %+v", pass, done, result)
+				}
+			}
+
+			var stored kdv1.RGBResourceManager
+			if err := r.Get(ctx, key, &stored); err == nil && controllerutil.ContainsFinalizer(&stored, cleanupFinalizer) {
+				t.Errorf("finalizer still stored")
+			} else if err != nil && !apierrors.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			var pods corev1.PodList
+			if err := r.List(ctx, &pods, client.InNamespace("other")); err != nil {
+				t.Fatal(err)
+			}
+			if len(pods.Items) != tt.wantChildren {
+				t.Fatalf("%d children left, want %d", len(pods.Items), tt.wantChildren)
+			}
+			for _, pod := range pods.Items {
+				if _, ok := pod.Annotations[ownerUIDAnnotation]; ok {
+					t.Errorf("child %s still carries the owner UID", pod.Name)
+				}
+				if _, labeled := pod.Labels[rgbNameLabel]; labeled != tt.wantLabeled {
+					t.Errorf("child %s labeled = %v, want %v", pod.Name, labeled, tt.wantLabeled)
+				}
+			}
+		})
+	}
+}
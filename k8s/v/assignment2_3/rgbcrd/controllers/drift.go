@@ -0,0 +1,389 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// fieldManager owns the fields the controller applies to children.
+const fieldManager = "rgbresourcemanager-controller"
+
+// driftInterval is how often children are compared with what they should be
+// when no event brings the RGB resource back, and how long the Drifted
+// condition stays True after a repair.
+const driftInterval = 10 * time.Minute
+
+// ignoredPaths are set by the API server or other controllers, not applied
+// by the controller, and never count as drift.
+var ignoredPaths = map[string]bool{
+	"apiVersion":                 true,
+	"kind":                       true,
+	"status":                     true,
+	"metadata.creationTimestamp": true,
+	"metadata.ownerReferences":   true,
+	"metadata.managedFields":     true,
+}
+
+// applyChild server-side applies obj under fieldManager, taking over the
+// fields other managers changed since. Only existing children are applied,
+// new ones are created so a same-named object is never taken over.
+func applyChild(ctx context.Context, c client.Client, obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+	return c.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// desiredChild returns what the controller applies to child: the child it
+// would create in its place, on the child's color.
+func (r *RGBResourceManagerReconciler) desiredChild(m childManager, rgb_resource *kdv1.RGBResourceManager, child client.Object, template corev1.PodTemplateSpec) (client.Object, error) {
+	newLabels := childLabels(rgb_resource)
+	newLabels[colorLabel] = child.GetLabels()[colorLabel]
+	desired := m.build(child.GetNamespace(), child.GetName(), newLabels, template)
+	if err := r.setOwner(rgb_resource, desired); err != nil {
+		return nil, err
+	}
+	return desired, nil
+}
+
+// repairDrift compares each child with what the controller would apply to
+// it now and applies it again where someone else changed an owned field.
+// Only children on the current color and template are compared, the
+// others are updated or replaced anyway. It returns a description of each
+// repair, and the repairs that failed as one error; a failed repair does not
+// keep the other children from being repaired.
+func (r *RGBResourceManagerReconciler) repairDrift(ctx context.Context, log logr.Logger, m childManager, rgb_resource *kdv1.RGBResourceManager, children []client.Object, template corev1.PodTemplateSpec, events *childEvents) ([]string, error) {
+	op := m.op()
+	var repairs []string
+	var failed []error
+	for _, child := range children {
+		desired, err := r.desiredChild(m, rgb_resource, child, template)
+		if err != nil {
+			return repairs, err
+		}
+
+		want, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+		if err != nil {
+			return repairs, err
+		}
+		have, err := runtime.DefaultUnstructuredConverter.ToUnstructured(child)
+		if err != nil {
+			return repairs, err
+		}
+		if gvk := rgb_resource.ChildGroupVersionKind(); gvk.Group == "" && gvk.Kind == "Pod" {
+			podMutableSpec(want)
+		}
+		paths := driftedPaths(want, have, nil, nil)
+		if len(paths) == 0 {
+			continue
+		}
+
+		changes := make([]string, 0, len(paths))
+		for _, path := range paths {
+			change := joinPath(path)
+			if managers := changedBy(child.GetManagedFields(), path); len(managers) > 0 {
+				change += " (by " + strings.Join(managers, ", ") + ")"
+			}
+			changes = append(changes, change)
+		}
+		repair := fmt.Sprintf("%s: %s", child.GetName(), strings.Join(changes, ", "))
+		log.Info("Reconciling RGB", "operation", "repair-"+op, "Name", child.GetName(), "Drifted", changes)
+		err = applyChild(ctx, r.Client, desired)
+		countOperation(string(rgb_resource.Spec.Kind), "repair", err)
+		if err != nil {
+			log.Info("Reconciling RGB", "operation", "repair-"+op, "Failed", child.GetName())
+			failed = append(failed, fmt.Errorf("repairing %s: %w", repair, err))
+			continue
+		}
+		log.Info("Reconciling RGB", "operation", "repair-"+op, "Success", child.GetName())
+		r.childEvent(child, rgb_resource, eventRepaired, "Set back "+strings.Join(changes, ", "))
+		events.add(eventRepaired, child.GetName())
+		repairs = append(repairs, repair)
+	}
+	return repairs, utilerrors.NewAggregate(failed)
+}
+
+// markDrift records the repairs of this reconcile in the Drifted condition.
+// Without new repairs the condition goes back to False once driftInterval
+// passed, so a repair stays visible for a while.
+func markDrift(rgb_resource *kdv1.RGBResourceManager, repairs []string) {
+	if len(repairs) > 0 {
+		setCondition(rgb_resource, kdv1.ConditionDrifted, metav1.ConditionTrue, kdv1.ReasonDriftRepaired,
+			strings.Join(repairs, "; "))
+		return
+	}
+	drifted := meta.FindStatusCondition(rgb_resource.Status.Conditions, kdv1.ConditionDrifted)
+	if drifted == nil || drifted.Status == metav1.ConditionTrue && time.Since(drifted.LastTransitionTime.Time) > driftInterval {
+		setCondition(rgb_resource, kdv1.ConditionDrifted, metav1.ConditionFalse, kdv1.ReasonAsExpected, "")
+	}
+}
+
+// podMutableSpec leaves in want the parts of a Pod spec that can change
+// after creation: the images, activeDeadlineSeconds and tolerations. The
+// rest is immutable, what admission set there can not be set back.
+func podMutableSpec(want map[string]interface{}) {
+	spec, ok := want["spec"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	mutable := map[string]interface{}{}
+	for _, key := range []string{"containers", "initContainers"} {
+		containers, ok := spec[key].([]interface{})
+		if !ok {
+			continue
+		}
+		images := make([]interface{}, 0, len(containers))
+		for _, c := range containers {
+			if c, ok := c.(map[string]interface{}); ok {
+				images = append(images, map[string]interface{}{"name": c["name"], "image": c["image"]})
+			}
+		}
+		mutable[key] = images
+	}
+	for _, key := range []string{"activeDeadlineSeconds", "tolerations"} {
+		if value, ok := spec[key]; ok {
+			mutable[key] = value
+		}
+	}
+	want["spec"] = mutable
+}
+
+// driftedPaths returns the paths under which have differs from the fields
+// set in want. Fields want does not set are left to others, so defaults and
+// fields of other controllers do not count. The same goes for list items:
+// items with a name are matched by it, other objects by content, and items
+// only have holds, such as volumes or tolerations added on admission, do
+// not count. Lists of scalars have to match as a whole.
+func driftedPaths(want interface{}, have interface{}, path []string, paths [][]string) [][]string {
+	if ignoredPaths[joinPath(path)] {
+		return paths
+	}
+	switch w := want.(type) {
+	case nil:
+		return paths
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok && len(w) > 0 {
+			return append(paths, path)
+		}
+		keys := make([]string, 0, len(w))
+		for key := range w {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			paths = driftedPaths(w[key], h[key], appendPath(path, key), paths)
+		}
+		return paths
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok {
+			return append(paths, path)
+		}
+		if namedItems(w) {
+			for _, item := range w {
+				name := item.(map[string]interface{})["name"]
+				j := indexByName(h, name)
+				if j < 0 {
+					paths = append(paths, appendPath(path, fmt.Sprintf("[name=%v]", name)))
+					continue
+				}
+				paths = driftedPaths(item, h[j], appendPath(path, fmt.Sprintf("[%d]", j)), paths)
+			}
+			return paths
+		}
+		if objectItems(w) {
+			for i, item := range w {
+				if !containsItem(h, item, appendPath(path, fmt.Sprintf("[%d]", i))) {
+					return append(paths, path)
+				}
+			}
+			return paths
+		}
+		if len(h) != len(w) {
+			return append(paths, path)
+		}
+		for i := range w {
+			paths = driftedPaths(w[i], h[i], appendPath(path, fmt.Sprintf("[%d]", i)), paths)
+		}
+		return paths
+	default:
+		if !sameValue(w, have) {
+			return append(paths, path)
+		}
+		return paths
+	}
+}
+
+// namedItems reports whether every item of list is an object with a name of
+// its own.
+func namedItems(list []interface{}) bool {
+	if len(list) == 0 {
+		return false
+	}
+	seen := map[interface{}]bool{}
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		name, ok := m["name"].(string)
+		if !ok || seen[name] {
+			return false
+		}
+		seen[name] = true
+	}
+	return true
+}
+
+// objectItems reports whether every item of list is an object.
+func objectItems(list []interface{}) bool {
+	for _, item := range list {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+// indexByName returns the index of the item of list called name, or -1.
+func indexByName(list []interface{}, name interface{}) int {
+	for i, item := range list {
+		if m, ok := item.(map[string]interface{}); ok && m["name"] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// containsItem reports whether an item of list has every field want sets.
+func containsItem(list []interface{}, want interface{}, path []string) bool {
+	for _, item := range list {
+		if len(driftedPaths(want, item, path, nil)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sameValue compares scalars, numbers by value whatever their Go type.
+func sameValue(a interface{}, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// changedBy returns the managers other than the controller that own path
+// according to the managed fields of the child. List items are keyed in
+// managed fields, so any item matches an index.
+func changedBy(entries []metav1.ManagedFieldsEntry, path []string) []string {
+	var managers []string
+	for _, entry := range entries {
+		if entry.Manager == fieldManager || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if ownsPath(fields, path) {
+			managers = append(managers, entry.Manager)
+		}
+	}
+	sort.Strings(managers)
+	return managers
+}
+
+// ownsPath reports whether the fieldsV1 set fields covers path.
+func ownsPath(fields map[string]interface{}, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	if strings.HasPrefix(path[0], "[") {
+		for key, child := range fields {
+			if !strings.HasPrefix(key, "k:") && !strings.HasPrefix(key, "i:") && !strings.HasPrefix(key, "v:") {
+				continue
+			}
+			if sub, ok := child.(map[string]interface{}); ok && ownsPath(sub, path[1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	sub, ok := fields["f:"+path[0]].(map[string]interface{})
+	return ok && ownsPath(sub, path[1:])
+}
+
+// appendPath returns path with segment added, leaving path alone.
+func appendPath(path []string, segment string) []string {
+	out := make([]string, len(path), len(path)+1)
+	copy(out, path)
+	return append(out, segment)
+}
+
+// joinPath formats path the way kubectl explain spells fields.
+func joinPath(path []string) string {
+	var b strings.Builder
+	for i, segment := range path {
+		if i > 0 && !strings.HasPrefix(segment, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(segment)
+	}
+	return b.String()
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// object decodes a JSON object the way the unstructured converter returns it.
+func object(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &obj); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestDriftedPaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		want  string
+		have  string
+		pod   bool
+		paths []string
+	}{
+		{
+			name: "no drift",
+			want: `{"spec": {"replicas": 1}}`,
+			have: `{"spec": {"replicas": 1}}`,
+		},
+		{
+			name:  "changed scalar",
+			want:  `{"spec": {"replicas": 1}}`,
+			have:  `{"spec": {"replicas": 2}}`,
+			paths: []string{"spec.replicas"},
+		},
+		{
+			name: "fields only others set",
+			want: `{"metadata": {"labels": {"color": "Red"}}}`,
+			have: `{"metadata": {"labels": {"color": "Red", "team": "a"}, "uid": "1"}, "spec": {"replicas": 1}}`,
+		},
+		{
+			name:  "removed field",
+			want:  `{"metadata": {"labels": {"color": "Red", "app": "rgb"}}}`,
+			have:  `{"metadata": {"labels": {"app": "rgb"}}}`,
+			paths: []string{"metadata.labels.color"},
+		},
+		{
+			name:  "removed map",
+			want:  `{"metadata": {"labels": {"color": "Red"}}}`,
+			have:  `{"metadata": {}}`,
+			paths: []string{"metadata.labels"},
+		},
+		{
+			name: "ignored paths",
+			want: `{"kind": "Pod", "status": {"phase": "Pending"}}`,
+			have: `{"kind": "Other", "status": {"phase": "Running"}}`,
+		},
+		{
+			name:  "named items are matched by name",
+			want:  `{"spec": {"containers": [{"name": "a", "image": "nginx"}, {"name": "b", "image": "busybox"}]}}`,
+			have:  `{"spec": {"containers": [{"name": "b", "image": "busybox:evil"}, {"name": "a", "image": "nginx"}]}}`,
+			paths: []string{"spec.containers[0].image"},
+		},
+		{
+			name: "named items added on admission",
+			want: `{"spec": {"volumes": [{"name": "data"}]}}`,
+			have: `{"spec": {"volumes": [{"name": "kube-api-access"}, {"name": "data"}]}}`,
+		},
+		{
+			name:  "missing named item",
+			want:  `{"spec": {"volumes": [{"name": "data"}]}}`,
+			have:  `{"spec": {"volumes": [{"name": "kube-api-access"}]}}`,
+			paths: []string{"spec.volumes[name=data]"},
+		},
+		{
+			name: "items without a name added on admission",
+			want: `{"spec": {"tolerations": [{"key": "team", "operator": "Exists"}]}}`,
+			have: `{"spec": {"tolerations": [{"key": "node.kubernetes.io/not-ready", "operator": "Exists"}, {"key": "team", "operator": "Exists"}]}}`,
+		},
+		{
+			name:  "missing item without a name",
+			want:  `{"spec": {"tolerations": [{"key": "team", "operator": "Exists"}]}}`,
+			have:  `{"spec": {"tolerations": [{"key": "node.kubernetes.io/not-ready", "operator": "Exists"}]}}`,
+			paths: []string{"spec.tolerations"},
+		},
+		{
+			name:  "scalar lists match as a whole",
+			want:  `{"spec": {"args": ["-v"]}}`,
+			have:  `{"spec": {"args": ["-v", "--debug"]}}`,
+			paths: []string{"spec.args"},
+		},
+		{
+			name: "immutable Pod fields are skipped",
+			want: `{"spec": {"containers": [{"name": "a", "image": "nginx", "resources": {"limits": {"cpu": "1"}}}], "restartPolicy": "Always"}}`,
+			have: `{"spec": {"containers": [{"name": "a", "image": "nginx", "resources": {"limits": {"cpu": "2"}}}], "restartPolicy": "Never"}}`,
+			pod:  true,
+		},
+		{
+			name:  "Pod images still count",
+			want:  `{"spec": {"containers": [{"name": "a", "image": "nginx"}], "initContainers": [{"name": "i", "image": "busybox"}]}}`,
+			have:  `{"spec": {"containers": [{"name": "a", "image": "nginx:evil"}], "initContainers": [{"name": "i", "image": "busybox"}]}}`,
+			pod:   true,
+			paths: []string{"spec.containers[0].image"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := object(t, tt.want)
+			if tt.pod {
+				podMutableSpec(want)
+			}
+			var got []string
+			for _, path := range driftedPaths(want, object(t, tt.have), nil, nil) {
+				got = append(got, joinPath(path))
+			}
+			if !reflect.DeepEqual(got, tt.paths) {
+				t.Errorf("driftedPaths() = %v, want %v", got, tt.paths)
+			}
+		})
+	}
+}
+
+func TestChangedBy(t *testing.T) {
+	entry := func(manager string, fields string) metav1.ManagedFieldsEntry {
+		return metav1.ManagedFieldsEntry{Manager: manager, FieldsV1: &metav1.FieldsV1{Raw: []byte(fields)}}
+	}
+	entries := []metav1.ManagedFieldsEntry{
+		entry(fieldManager, `{"f:metadata": {"f:labels": {"f:color": {}}}, "f:spec": {"f:containers": {"k:{\"name\":\"a\"}": {"f:image": {}}}}}`),
+		entry("kubectl-edit", `{"f:spec": {"f:containers": {"k:{\"name\":\"a\"}": {"f:image": {}}}}}`),
+		entry("kubectl-label", `{"f:metadata": {"f:labels": {"f:color": {}}}}`),
+		entry("broken", `not json`),
+	}
+	tests := []struct {
+		name string
+		path []string
+		want []string
+	}{
+		{
+			name: "list item",
+			path: []string{"spec", "containers", "[0]", "image"},
+			want: []string{"kubectl-edit"},
+		},
+		{
+			name: "map key",
+			path: []string{"metadata", "labels", "color"},
+			want: []string{"kubectl-label"},
+		},
+		{
+			name: "owned by the controller alone",
+			path: []string{"spec", "replicas"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changedBy(entries, tt.path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changedBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// Event reasons for what a reconcile did to the children. Failures are
+// reported with the reason of the Degraded condition.
+const (
+	eventCreated    = "Created"
+	eventDeleted    = "Deleted"
+	eventRecolored  = "Recolored"
+	eventUpdated    = "Updated"
+	eventReplaced   = "Replaced"
+	eventTerminated = "Terminated"
+	eventReady      = "Ready"
+	eventNotReady   = "NotReady"
+	eventRecovered  = "Recovered"
+	eventRepaired   = "Repaired"
+	eventAdopted    = "Adopted"
+	eventReleased   = "Released"
+)
+
+// eventNamesShown caps the children named in one event.
+const eventNamesShown = 5
+
+// childEvents collects what one reconcile did to the children, so a scale
+// storm ends up as one event per action on the RGB resource rather than one
+// per child. Events the API server sees repeatedly are folded further by
+// the correlator of the event broadcaster.
+type childEvents struct {
+	reasons []string
+	names   map[string][]string
+}
+
+// add records that reason happened to the child called name.
+func (e *childEvents) add(reason string, name string) {
+	if e.names == nil {
+		e.names = map[string][]string{}
+	}
+	if _, ok := e.names[reason]; !ok {
+		e.reasons = append(e.reasons, reason)
+	}
+	e.names[reason] = append(e.names[reason], name)
+}
+
+// flushEvents emits one Normal event on the RGB resource per action of the
+// reconcile, in the order they first happened.
+func (r *RGBResourceManagerReconciler) flushEvents(rgb_resource *kdv1.RGBResourceManager, op string, events *childEvents) {
+	for _, reason := range events.reasons {
+		names := events.names[reason]
+		shown := names
+		more := ""
+		if len(names) > eventNamesShown {
+			shown = names[:eventNamesShown]
+			more = fmt.Sprintf(" and %d more", len(names)-eventNamesShown)
+		}
+		r.Recorder.Eventf(rgb_resource, corev1.EventTypeNormal, reason, "%s %d %s(s): %s%s",
+			reason, len(names), op, strings.Join(shown, ", "), more)
+	}
+}
+
+// childEvent emits an event on a child, for changes made to it in place
+// that are best seen from the child itself.
+func (r *RGBResourceManagerReconciler) childEvent(child client.Object, rgb_resource *kdv1.RGBResourceManager, reason string, message string) {
+	r.Recorder.Eventf(child, corev1.EventTypeNormal, reason, "%s by RGBResourceManager %s/%s",
+		message, rgb_resource.Namespace, rgb_resource.Name)
+}
+
+// conditionEvents emits events for the condition transitions between
+// original and rgb_resource: becoming ready or not, and failures as they
+// start or change reason. A failure repeating on every retry is not
+// reported again.
+func (r *RGBResourceManagerReconciler) conditionEvents(original *kdv1.RGBResourceManager, rgb_resource *kdv1.RGBResourceManager) {
+	before := meta.FindStatusCondition(original.Status.Conditions, kdv1.ConditionAvailable)
+	after := meta.FindStatusCondition(rgb_resource.Status.Conditions, kdv1.ConditionAvailable)
+	wasReady := before != nil && before.Status == metav1.ConditionTrue
+	if after != nil {
+		switch isReady := after.Status == metav1.ConditionTrue; {
+		case isReady && !wasReady:
+			r.Recorder.Event(rgb_resource, corev1.EventTypeNormal, eventReady, after.Message)
+		case !isReady && wasReady:
+			r.Recorder.Eventf(rgb_resource, corev1.EventTypeWarning, eventNotReady, "%s: %s", after.Reason, after.Message)
+		}
+	}
+
+	before = meta.FindStatusCondition(original.Status.Conditions, kdv1.ConditionDegraded)
+	after = meta.FindStatusCondition(rgb_resource.Status.Conditions, kdv1.ConditionDegraded)
+	wasDegraded := before != nil && before.Status == metav1.ConditionTrue
+	if after == nil {
+		return
+	}
+	switch {
+	case after.Status == metav1.ConditionTrue && (!wasDegraded || before.Reason != after.Reason):
+		r.Recorder.Event(rgb_resource, corev1.EventTypeWarning, after.Reason, after.Message)
+	case after.Status != metav1.ConditionTrue && wasDegraded:
+		r.Recorder.Eventf(rgb_resource, corev1.EventTypeNormal, eventRecovered, "No longer %s", before.Reason)
+	}
+}
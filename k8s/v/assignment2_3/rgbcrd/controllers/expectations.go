@@ -0,0 +1,200 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// expectationsTimeout bounds how long a RGB resource waits for its creates
+// and deletes to be observed, so a missed watch event does not stall it.
+const expectationsTimeout = 5 * time.Minute
+
+// expectation is what one RGB resource is waiting to see in the cache.
+type expectation struct {
+	creates int
+	// deletes holds the UIDs of the children deleted but not yet seen
+	// going. A child is seen going once, whether by its deletion
+	// timestamp or its delete event.
+	deletes   map[types.UID]bool
+	timestamp time.Time
+}
+
+// expectations tracks the creates and deletes each RGB resource has in
+// flight, the way the ReplicaSet controller does. The cache lags behind the
+// API server, so a reconcile right after creating children would see too
+// few of them and create more; until the cache caught up the counts are
+// left alone.
+type expectations struct {
+	mu    sync.Mutex
+	byRGB map[types.NamespacedName]*expectation
+}
+
+func newExpectations() *expectations {
+	return &expectations{byRGB: map[types.NamespacedName]*expectation{}}
+}
+
+// get returns the expectation of key, creating it when needed. The caller
+// holds the lock.
+func (e *expectations) get(key types.NamespacedName) *expectation {
+	exp, ok := e.byRGB[key]
+	if !ok {
+		exp = &expectation{deletes: map[types.UID]bool{}}
+		e.byRGB[key] = exp
+	}
+	return exp
+}
+
+// expectCreations records that count children of key are being created.
+func (e *expectations) expectCreations(key types.NamespacedName, count int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp := e.get(key)
+	exp.creates += count
+	exp.timestamp = time.Now()
+}
+
+// expectDeletions records that the children with the given UIDs are being
+// deleted.
+func (e *expectations) expectDeletions(key types.NamespacedName, uids ...types.UID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp := e.get(key)
+	for _, uid := range uids {
+		exp.deletes[uid] = true
+	}
+	exp.timestamp = time.Now()
+}
+
+// creationObserved lowers the creates of key by count, for creates seen in
+// the cache or ones that failed and will never show up.
+func (e *expectations) creationObserved(key types.NamespacedName, count int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if exp, ok := e.byRGB[key]; ok && exp.creates > 0 {
+		exp.creates -= count
+		if exp.creates < 0 {
+			exp.creates = 0
+		}
+	}
+}
+
+// deletionObserved removes uid from the deletes of key.
+func (e *expectations) deletionObserved(key types.NamespacedName, uid types.UID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if exp, ok := e.byRGB[key]; ok {
+		delete(exp.deletes, uid)
+	}
+}
+
+// satisfied reports whether the cache has caught up with everything key
+// did, or gave up waiting for it. It returns the creates and deletes still
+// pending otherwise.
+func (e *expectations) satisfied(key types.NamespacedName) (bool, int, int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	exp, ok := e.byRGB[key]
+	if !ok {
+		return true, 0, 0
+	}
+	if exp.creates <= 0 && len(exp.deletes) == 0 {
+		return true, 0, 0
+	}
+	if time.Since(exp.timestamp) > expectationsTimeout {
+		// Start over, the next reconcile acts on what the cache has.
+		delete(e.byRGB, key)
+		return true, 0, 0
+	}
+	return false, exp.creates, len(exp.deletes)
+}
+
+// forget drops the expectations of a RGB resource that is gone.
+func (e *expectations) forget(key types.NamespacedName) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.byRGB, key)
+}
+
+// expectationsHandler lowers the expectations of the RGB resource a child
+// belongs to as the child shows up or goes away in the cache, then
+// enqueues the RGB resource so it acts on what it waited for.
+type expectationsHandler struct {
+	expectations *expectations
+}
+
+var _ handler.EventHandler = &expectationsHandler{}
+
+// Create implements handler.EventHandler.
+func (h *expectationsHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	if key, ok := rgbKeyOf(e.Object); ok {
+		h.expectations.creationObserved(key, 1)
+		q.Add(reconcile.Request{NamespacedName: key})
+	}
+}
+
+// Update implements handler.EventHandler. A child is as good as gone once
+// it has a deletion timestamp, the reconcile no longer counts it.
+func (h *expectationsHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	if e.ObjectOld.GetDeletionTimestamp() != nil || e.ObjectNew.GetDeletionTimestamp() == nil {
+		return
+	}
+	if key, ok := rgbKeyOf(e.ObjectNew); ok {
+		h.expectations.deletionObserved(key, e.ObjectNew.GetUID())
+		q.Add(reconcile.Request{NamespacedName: key})
+	}
+}
+
+// Delete implements handler.EventHandler.
+func (h *expectationsHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	if key, ok := rgbKeyOf(e.Object); ok {
+		h.expectations.deletionObserved(key, e.Object.GetUID())
+		q.Add(reconcile.Request{NamespacedName: key})
+	}
+}
+
+// Generic implements handler.EventHandler.
+func (h *expectationsHandler) Generic(event.GenericEvent, workqueue.RateLimitingInterface) {}
+
+// rgbKeyOf returns the RGB resource obj is a child of. Objects controlled
+// by anything else, such as the pods of a Deployment child, are not
+// children even though they carry the labels.
+func rgbKeyOf(obj client.Object) (types.NamespacedName, bool) {
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		if owner.APIVersion != apiGVStr || owner.Kind != "RGBResourceManager" {
+			return types.NamespacedName{}, false
+		}
+		return types.NamespacedName{Namespace: obj.GetNamespace(), Name: owner.Name}, true
+	}
+	// Children in another namespace are tied to the RGB resource by their
+	// labels alone.
+	name := obj.GetLabels()[rgbNameLabel]
+	namespace := obj.GetLabels()[rgbNamespaceLabel]
+	if name == "" || namespace == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, true
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestExpectations(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "rgb"}
+	tests := []struct {
+		name        string
+		steps       func(e *expectations)
+		wantOK      bool
+		wantCreates int
+		wantDeletes int
+	}{
+		{
+			name:   "nothing expected",
+			steps:  func(e *expectations) {},
+			wantOK: true,
+		},
+		{
+			name: "creates not all observed",
+			steps: func(e *expectations) {
+				e.expectCreations(key, 3)
+				e.creationObserved(key, 1)
+			},
+			wantCreates: 2,
+		},
+		{
+			name: "creates all observed",
+			steps: func(e *expectations) {
+				e.expectCreations(key, 2)
+				e.creationObserved(key, 1)
+				e.creationObserved(key, 1)
+			},
+			wantOK: true,
+		},
+		{
+			name: "creates observed beyond the expected do not carry over",
+			steps: func(e *expectations) {
+				e.expectCreations(key, 1)
+				e.creationObserved(key, 3)
+				e.expectCreations(key, 1)
+			},
+			wantCreates: 1,
+		},
+		{
+			name: "a child seen going twice counts once",
+			steps: func(e *expectations) {
+				e.expectDeletions(key, "a", "b")
+				e.deletionObserved(key, "a")
+				e.deletionObserved(key, "a")
+			},
+			wantDeletes: 1,
+		},
+		{
+			name: "deletes all observed",
+			steps: func(e *expectations) {
+				e.expectDeletions(key, "a", "b")
+				e.deletionObserved(key, "b")
+				e.deletionObserved(key, "a")
+			},
+			wantOK: true,
+		},
+		{
+			name: "other RGB resources do not count",
+			steps: func(e *expectations) {
+				other := types.NamespacedName{Namespace: "default", Name: "other"}
+				e.expectCreations(key, 1)
+				e.creationObserved(other, 1)
+			},
+			wantCreates: 1,
+		},
+		{
+			name: "given up after the timeout",
+			steps: func(e *expectations) {
+				e.expectCreations(key, 1)
+				e.byRGB[key].timestamp = time.Now().Add(-expectationsTimeout - time.Second)
+			},
+			wantOK: true,
+		},
+		{
+			name: "forgotten",
+			steps: func(e *expectations) {
+				e.expectCreations(key, 1)
+				e.forget(key)
+			},
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newExpectations()
+			tt.steps(e)
+			ok, creates, deletes := e.satisfied(key)
+			if ok != tt.wantOK || creates != tt.wantCreates || deletes != tt.wantDeletes {
+				t.Errorf("satisfied() = %v, %d, %d, want %v, %d, %d", ok, creates, deletes, tt.wantOK, tt.wantCreates, tt.wantDeletes)
+			}
+		})
+	}
+}
+
+func TestExpectationsTimeoutStartsOver(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "rgb"}
+	e := newExpectations()
+	e.expectCreations(key, 2)
+	e.byRGB[key].timestamp = time.Now().Add(-expectationsTimeout - time.Second)
+	e.satisfied(key)
+	// A late event for the old creates must not eat into new ones.
+	e.creationObserved(key, 1)
+	e.expectCreations(key, 1)
+	if ok, creates, _ := e.satisfied(key); ok || creates != 1 {
+		t.Errorf("satisfied() = %v, %d, want false, 1", ok, creates)
+	}
+}
+
+func TestExpectationsHandler(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "rgb"}
+	controller := true
+	owned := func(apiVersion string, kind string, name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "child",
+			Namespace: "default",
+			UID:       "child-uid",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: apiVersion, Kind: kind, Name: name, Controller: &controller,
+			}},
+		}}
+	}
+	labeled := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      "child",
+		Namespace: "elsewhere",
+		UID:       "child-uid",
+		Labels:    map[string]string{rgbNameLabel: "rgb", rgbNamespaceLabel: "default"},
+	}}
+	now := metav1.Now()
+	deleting := func(pod *corev1.Pod) *corev1.Pod {
+		pod = pod.DeepCopy()
+		pod.DeletionTimestamp = &now
+		return pod
+	}
+
+	tests := []struct {
+		name        string
+		send        func(h *expectationsHandler, q workqueue.RateLimitingInterface)
+		wantCreates int
+		wantDeletes int
+		wantQueued  int
+	}{
+		{
+			name: "create of an owned child",
+			send: func(h *expectationsHandler, q workqueue.RateLimitingInterface) {
+				h.Create(event.CreateEvent{Object: owned(apiGVStr, "RGBResourceManager", "rgb")}, q)
+			},
+			wantCreates: 1,
+			wantDeletes: 1,
+			wantQueued:  1,
+		},
+		{
+			name: "create of a child in another namespace",
+			send: func(h *expectationsHandler, q workqueue.RateLimitingInterface) {
+				h.Create(event.CreateEvent{Object: labeled}, q)
+			},
+			wantCreates: 1,
+			wantDeletes: 1,
+			wantQueued:  1,
+		},
+		{
+			name: "create of a pod owned by something else",
+			send: func(h *expectationsHandler, q workqueue.RateLimitingInterface) {
+				h.Create(event.CreateEvent{Object: owned("apps/v1", "ReplicaSet", "rgb")}, q)
+			},
+			wantCreates: 2,
+			wantDeletes: 1,
+		},
+		{
+			name: "deletion timestamp set",
+			send: func(h *expectationsHandler, q workqueue.RateLimitingInterface) {
+				old := owned(apiGVStr, "RGBResourceManager", "rgb")
+				h.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: deleting(old)}, q)
+			},
+			wantCreates: 2,
+			wantQueued:  1,
+		},
+		{
+			name: "update of a child already going",
+			send: func(h *expectationsHandler, q workqueue.RateLimitingInterface) {
+				old := deleting(owned(apiGVStr, "RGBResourceManager", "rgb"))
+				h.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: old}, q)
+			},
+			wantCreates: 2,
+			wantDeletes: 1,
+		},
+		{
+			name: "delete",
+			send: func(h *expectationsHandler, q workqueue.RateLimitingInterface) {
+				h.Delete(event.DeleteEvent{Object: labeled}, q)
+			},
+			wantCreates: 2,
+			wantQueued:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newExpectations()
+			e.expectCreations(key, 2)
+			e.expectDeletions(key, "child-uid")
+			q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer q.ShutDown()
+
+			tt.send(&expectationsHandler{expectations: e}, q)
+			if _, creates, deletes := e.satisfied(key); creates != tt.wantCreates || deletes != tt.wantDeletes {
+				t.Errorf("pending = %d creates, %d deletes, want %d, %d", creates, deletes, tt.wantCreates, tt.wantDeletes)
+			}
+			if got := q.Len(); got != tt.wantQueued {
+				t.Errorf("queued %d requests, want %d", got, tt.wantQueued)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// outcomeSuccess is the outcome label of operations that went through,
+// failed ones carry the reason of the API error instead.
+const outcomeSuccess = "Success"
+
+var (
+	// childOperations counts what the controller did to children.
+	childOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rgb_child_operations_total",
+		Help: "Operations on RGB children by kind, operation and outcome, the API error reason for failures.",
+	}, []string{"kind", "operation", "outcome"})
+
+	// timeToReady measures how long RGB resources take to become available
+	// after they were created or started progressing.
+	timeToReady = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rgb_time_to_ready_seconds",
+		Help:    "Time from an RGB resource starting to progress until all of its children are ready.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(childOperations, timeToReady)
+}
+
+// countOperation records an operation on a child of the given kind.
+func countOperation(kind string, operation string, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = string(apierrors.ReasonForError(err))
+		if outcome == "" {
+			outcome = "Unknown"
+		}
+	}
+	childOperations.WithLabelValues(kind, operation, outcome).Inc()
+}
+
+// observeReady records the time to ready when the RGB resource became
+// available in this reconcile.
+func observeReady(original *kdv1.RGBResourceManager, rgb_resource *kdv1.RGBResourceManager) {
+	if meta.IsStatusConditionTrue(original.Status.Conditions, kdv1.ConditionAvailable) ||
+		!meta.IsStatusConditionTrue(rgb_resource.Status.Conditions, kdv1.ConditionAvailable) {
+		return
+	}
+	start := rgb_resource.CreationTimestamp.Time
+	if progressing := meta.FindStatusCondition(original.Status.Conditions, kdv1.ConditionProgressing); progressing != nil &&
+		progressing.Status == metav1.ConditionTrue {
+		start = progressing.LastTransitionTime.Time
+	}
+	timeToReady.WithLabelValues(string(rgb_resource.Spec.Kind)).Observe(time.Since(start).Seconds())
+}
+
+var (
+	desiredDesc = prometheus.NewDesc("rgb_desired_replicas",
+		"Children an RGB resource asks for.", []string{"namespace", "name", "kind"}, nil)
+	replicasDesc = prometheus.NewDesc("rgb_replicas",
+		"Children an RGB resource has.", []string{"namespace", "name", "kind"}, nil)
+	readyDesc = prometheus.NewDesc("rgb_ready_replicas",
+		"Ready children of an RGB resource.", []string{"namespace", "name", "kind"}, nil)
+	childrenDesc = prometheus.NewDesc("rgb_children",
+		"Children of all RGB resources by the namespace they live in, kind and color.", []string{"namespace", "kind", "color"}, nil)
+)
+
+// fleetCollector reports the state of every RGB resource from the cache on
+// each scrape, so deleted RGB resources and colors no longer used drop out
+// without any bookkeeping.
+type fleetCollector struct {
+	reader client.Reader
+	log    logr.Logger
+}
+
+var _ prometheus.Collector = &fleetCollector{}
+
+// Describe implements prometheus.Collector.
+func (c *fleetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- desiredDesc
+	ch <- replicasDesc
+	ch <- readyDesc
+	ch <- childrenDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *fleetCollector) Collect(ch chan<- prometheus.Metric) {
+	var rgbs kdv1.RGBResourceManagerList
+	if err := c.reader.List(context.Background(), &rgbs); err != nil {
+		c.log.Error(err, "unable to list RGB resources for metrics")
+		return
+	}
+
+	type childKey struct{ namespace, kind, color string }
+	children := map[childKey]int32{}
+	for _, rgb := range rgbs.Items {
+		kind := string(rgb.Spec.Kind)
+		ch <- prometheus.MustNewConstMetric(desiredDesc, prometheus.GaugeValue, float64(rgb.Spec.Count), rgb.Namespace, rgb.Name, kind)
+		ch <- prometheus.MustNewConstMetric(replicasDesc, prometheus.GaugeValue, float64(rgb.Status.Replicas), rgb.Namespace, rgb.Name, kind)
+		ch <- prometheus.MustNewConstMetric(readyDesc, prometheus.GaugeValue, float64(rgb.Status.ReadyReplicas), rgb.Namespace, rgb.Name, kind)
+		for _, color := range rgb.Status.Colors {
+			children[childKey{childNamespace(&rgb), kind, string(color.Color)}] += color.Replicas
+		}
+	}
+	for key, count := range children {
+		ch <- prometheus.MustNewConstMetric(childrenDesc, prometheus.GaugeValue, float64(count), key.namespace, key.kind, key.color)
+	}
+}
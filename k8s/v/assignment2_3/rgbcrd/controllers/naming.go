@@ -0,0 +1,87 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// childNames returns the names of n new children in namespace. Ordinal
+// names take the lowest ordinals no object of the kind in the namespace
+// uses: children still going away keep theirs until they are gone, and an
+// object that is not a child is never applied over.
+func (r *RGBResourceManagerReconciler) childNames(ctx context.Context, m childManager, rgb_resource *kdv1.RGBResourceManager, namespace string, n int) ([]string, error) {
+	names := make([]string, 0, n)
+	if namingPolicy(rgb_resource) == kdv1.NamingUUID {
+		for i := 0; i < n; i++ {
+			names = append(names, rgb_resource.Name+"-"+uuid.New().String())
+		}
+		return names, nil
+	}
+
+	existing, err := m.list(ctx, r, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	taken := map[int]bool{}
+	for _, obj := range existing {
+		if ordinal, ok := ordinalOf(rgb_resource.Name, obj.GetName()); ok {
+			taken[ordinal] = true
+		}
+	}
+	for ordinal := 0; len(names) < n; ordinal++ {
+		if !taken[ordinal] {
+			names = append(names, rgb_resource.Name+"-"+strconv.Itoa(ordinal))
+		}
+	}
+	return names, nil
+}
+
+// ordinalOf returns the ordinal of a child named <rgbName>-<ordinal>. Names
+// with a UUID, or an ordinal with leading zeros, have none.
+func ordinalOf(rgbName string, name string) (int, bool) {
+	suffix := strings.TrimPrefix(name, rgbName+"-")
+	if suffix == name || suffix == "" || len(suffix) > 1 && suffix[0] == '0' {
+		return 0, false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// namingPolicy returns the naming policy of the RGB resource, defaulted for
+// objects created before the field existed.
+func namingPolicy(rgb_resource *kdv1.RGBResourceManager) kdv1.RGBNamingPolicy {
+	if rgb_resource.Spec.NamingPolicy == "" {
+		return kdv1.NamingOrdinal
+	}
+	return rgb_resource.Spec.NamingPolicy
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import "testing"
+
+func TestOrdinalOf(t *testing.T) {
+	tests := []struct {
+		name        string
+		wantOrdinal int
+		wantOK      bool
+	}{
+		{"rgb-0", 0, true},
+		{"rgb-7", 7, true},
+		{"rgb-12", 12, true},
+		{"rgb-07", 0, false},
+		{"rgb-", 0, false},
+		{"rgb", 0, false},
+		{"rgb-+1", 0, false},
+		{"rgb--1", 0, false},
+		{"rgb-1e3", 0, false},
+		{"rgb-0c6f3d0e-8a4b-4c1e-9d2f-6a1e9b4f7c3d", 0, false},
+		// Children of another RGB resource whose name starts alike.
+		{"rgb-blue-1", 0, false},
+		{"other-1", 0, false},
+		{"rgb-99999999999999999999", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ordinal, ok := ordinalOf("rgb", tt.name)
+			if ordinal != tt.wantOrdinal || ok != tt.wantOK {
+				t.Errorf("ordinalOf(%q) = %d, %v, want %d, %v", tt.name, ordinal, ok, tt.wantOrdinal, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sort"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// colorTarget is the number of children a color should have.
+type colorTarget struct {
+	color   string
+	desired int
+}
+
+// colorTargets splits desired children across the palette of the RGB
+// resource, in palette order. Counts are satisfied first, as far as desired
+// goes, the rest is split by weight. Without a palette, or without weights
+// to split the rest by, it goes to spec.color.
+func colorTargets(rgb_resource *kdv1.RGBResourceManager, desired int) []colorTarget {
+	palette := rgb_resource.Spec.Palette
+	if len(palette) == 0 {
+		return []colorTarget{{color: string(rgb_resource.Spec.Color), desired: desired}}
+	}
+
+	targets := make([]colorTarget, len(palette))
+	remaining := desired
+	totalWeight := 0
+	for i, entry := range palette {
+		targets[i].color = string(entry.Color)
+		if entry.Count != nil {
+			n := int(*entry.Count)
+			if n > remaining {
+				n = remaining
+			}
+			targets[i].desired = n
+			remaining -= n
+		} else {
+			totalWeight += paletteWeight(entry)
+		}
+	}
+	if remaining == 0 {
+		return targets
+	}
+	if totalWeight == 0 {
+		return addColorTarget(targets, string(rgb_resource.Spec.Color), remaining)
+	}
+
+	// Largest remainder method: everyone gets the floor of their share, the
+	// children left over go to the largest remainders, earlier entries first.
+	type share struct {
+		index     int
+		remainder int
+	}
+	var shares []share
+	assigned := 0
+	for i, entry := range palette {
+		if entry.Count != nil {
+			continue
+		}
+		weight := paletteWeight(entry)
+		targets[i].desired = remaining * weight / totalWeight
+		assigned += targets[i].desired
+		shares = append(shares, share{index: i, remainder: remaining * weight % totalWeight})
+	}
+	sort.SliceStable(shares, func(i, j int) bool {
+		return shares[i].remainder > shares[j].remainder
+	})
+	for i := 0; i < remaining-assigned; i++ {
+		targets[shares[i].index].desired++
+	}
+	return targets
+}
+
+// paletteWeight returns the weight of a palette entry without a count.
+func paletteWeight(entry kdv1.RGBPaletteEntry) int {
+	if entry.Weight == nil {
+		return 1
+	}
+	return int(*entry.Weight)
+}
+
+// addColorTarget adds n children to the target of color.
+func addColorTarget(targets []colorTarget, color string, n int) []colorTarget {
+	for i := range targets {
+		if targets[i].color == color {
+			targets[i].desired += n
+			return targets
+		}
+	}
+	return append(targets, colorTarget{color: color, desired: n})
+}
+
+// colorAssignment is the color every child of a RGB resource should carry.
+type colorAssignment struct {
+	byChild map[string]string
+	// surplus marks the children beyond what the targets ask for. They keep
+	// their color, scaling down removes them.
+	surplus map[string]bool
+	// unassigned are the colors of the children still to be created.
+	unassigned []string
+}
+
+// assignColors hands out the targets to children, moving as few of them to
+// another color as possible. The oldest children keep their color first.
+func assignColors(targets []colorTarget, children []client.Object) colorAssignment {
+	left := map[string]int{}
+	for _, t := range targets {
+		left[t.color] += t.desired
+	}
+	sorted := make([]client.Object, len(children))
+	copy(sorted, children)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		iCreated := sorted[i].GetCreationTimestamp()
+		jCreated := sorted[j].GetCreationTimestamp()
+		if !iCreated.Equal(&jCreated) {
+			return iCreated.Before(&jCreated)
+		}
+		return sorted[i].GetName() < sorted[j].GetName()
+	})
+
+	a := colorAssignment{byChild: map[string]string{}, surplus: map[string]bool{}}
+	var pending []client.Object
+	for _, child := range sorted {
+		color := child.GetLabels()[colorLabel]
+		if left[color] > 0 {
+			left[color]--
+			a.byChild[child.GetName()] = color
+			continue
+		}
+		pending = append(pending, child)
+	}
+	for _, child := range pending {
+		color := ""
+		for _, t := range targets {
+			if left[t.color] > 0 {
+				color = t.color
+				break
+			}
+		}
+		if color == "" {
+			a.byChild[child.GetName()] = child.GetLabels()[colorLabel]
+			a.surplus[child.GetName()] = true
+			continue
+		}
+		left[color]--
+		a.byChild[child.GetName()] = color
+	}
+
+	// New children take the remaining colors round robin, so a partial
+	// scale up still mixes them.
+	for more := true; more; {
+		more = false
+		for _, t := range targets {
+			if left[t.color] > 0 {
+				a.unassigned = append(a.unassigned, t.color)
+				left[t.color]--
+				more = true
+			}
+		}
+	}
+	return a
+}
+
+// colorStatus counts the children per color, the target colors first in
+// palette order, then any other color children still carry.
+func colorStatus(targets []colorTarget, children []client.Object, isReady func(client.Object) bool) []kdv1.RGBColorStatus {
+	counts := map[string]*kdv1.RGBColorStatus{}
+	var order, others []string
+	for _, t := range targets {
+		counts[t.color] = &kdv1.RGBColorStatus{Color: kdv1.RGBColor(t.color), Desired: int32(t.desired)}
+		order = append(order, t.color)
+	}
+	for _, child := range children {
+		color := child.GetLabels()[colorLabel]
+		status, ok := counts[color]
+		if !ok {
+			status = &kdv1.RGBColorStatus{Color: kdv1.RGBColor(color)}
+			counts[color] = status
+			others = append(others, color)
+		}
+		status.Replicas++
+		if isReady(child) {
+			status.ReadyReplicas++
+		}
+	}
+	sort.Strings(others)
+
+	statuses := make([]kdv1.RGBColorStatus, 0, len(counts))
+	for _, color := range append(order, others...) {
+		statuses = append(statuses, *counts[color])
+	}
+	return statuses
+}
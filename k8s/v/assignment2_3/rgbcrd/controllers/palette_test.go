@@ -0,0 +1,200 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+// testPod returns a Pod child of color, created age minutes after a fixed time.
+func testPod(name string, color string, age int) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:              name,
+		Labels:            map[string]string{colorLabel: color},
+		CreationTimestamp: metav1.NewTime(time.Date(2021, 1, 1, 0, age, 0, 0, time.UTC)),
+	}}
+}
+
+func TestColorTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		color   kdv1.RGBColor
+		palette []kdv1.RGBPaletteEntry
+		desired int
+		want    []colorTarget
+	}{
+		{
+			name:    "no palette",
+			color:   kdv1.RedColor,
+			desired: 3,
+			want:    []colorTarget{{"Red", 3}},
+		},
+		{
+			name:  "counts are clamped to desired, in order",
+			color: kdv1.RedColor,
+			palette: []kdv1.RGBPaletteEntry{
+				{Color: kdv1.RedColor, Count: int32Ptr(4)},
+				{Color: kdv1.GreenColor, Count: int32Ptr(2)},
+			},
+			desired: 5,
+			want:    []colorTarget{{"Red", 4}, {"Green", 1}},
+		},
+		{
+			name:  "counts first, the rest by weight",
+			color: kdv1.RedColor,
+			palette: []kdv1.RGBPaletteEntry{
+				{Color: kdv1.RedColor, Count: int32Ptr(1)},
+				{Color: kdv1.GreenColor, Weight: int32Ptr(3)},
+				{Color: kdv1.BlueColor, Weight: int32Ptr(1)},
+			},
+			desired: 5,
+			want:    []colorTarget{{"Red", 1}, {"Green", 3}, {"Blue", 1}},
+		},
+		{
+			name:  "equal remainders go to earlier entries",
+			color: kdv1.RedColor,
+			palette: []kdv1.RGBPaletteEntry{
+				{Color: kdv1.RedColor},
+				{Color: kdv1.GreenColor},
+				{Color: kdv1.BlueColor},
+			},
+			desired: 5,
+			want:    []colorTarget{{"Red", 2}, {"Green", 2}, {"Blue", 1}},
+		},
+		{
+			name:  "largest remainder wins over larger weight",
+			color: kdv1.RedColor,
+			palette: []kdv1.RGBPaletteEntry{
+				{Color: kdv1.RedColor, Weight: int32Ptr(1)},
+				{Color: kdv1.GreenColor, Weight: int32Ptr(2)},
+			},
+			desired: 2,
+			want:    []colorTarget{{"Red", 1}, {"Green", 1}},
+		},
+		{
+			name:  "without weights the rest goes to spec.color",
+			color: kdv1.BlueColor,
+			palette: []kdv1.RGBPaletteEntry{
+				{Color: kdv1.RedColor, Count: int32Ptr(1)},
+				{Color: kdv1.GreenColor, Weight: int32Ptr(0)},
+			},
+			desired: 3,
+			want:    []colorTarget{{"Red", 1}, {"Green", 0}, {"Blue", 2}},
+		},
+		{
+			name:  "spec.color already in the palette",
+			color: kdv1.RedColor,
+			palette: []kdv1.RGBPaletteEntry{
+				{Color: kdv1.RedColor, Count: int32Ptr(1)},
+			},
+			desired: 3,
+			want:    []colorTarget{{"Red", 3}},
+		},
+		{
+			name:  "zero desired",
+			color: kdv1.RedColor,
+			palette: []kdv1.RGBPaletteEntry{
+				{Color: kdv1.RedColor, Count: int32Ptr(2)},
+				{Color: kdv1.GreenColor},
+			},
+			desired: 0,
+			want:    []colorTarget{{"Red", 0}, {"Green", 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rgb := &kdv1.RGBResourceManager{Spec: kdv1.RGBResourceManagerSpec{Color: tt.color, Palette: tt.palette}}
+			if got := colorTargets(rgb, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("colorTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssignColors(t *testing.T) {
+	tests := []struct {
+		name           string
+		targets        []colorTarget
+		children       []client.Object
+		wantByChild    map[string]string
+		wantSurplus    map[string]bool
+		wantUnassigned []string
+	}{
+		{
+			name:        "children on their color stay",
+			targets:     []colorTarget{{"Red", 1}, {"Green", 1}},
+			children:    []client.Object{testPod("a", "Red", 0), testPod("b", "Green", 1)},
+			wantByChild: map[string]string{"a": "Red", "b": "Green"},
+			wantSurplus: map[string]bool{},
+		},
+		{
+			name:    "only the children over their color move",
+			targets: []colorTarget{{"Red", 1}, {"Green", 2}},
+			children: []client.Object{
+				testPod("a", "Red", 0), testPod("b", "Red", 1), testPod("c", "Green", 2),
+			},
+			wantByChild: map[string]string{"a": "Red", "b": "Green", "c": "Green"},
+			wantSurplus: map[string]bool{},
+		},
+		{
+			name:        "the oldest child keeps its color",
+			targets:     []colorTarget{{"Red", 1}, {"Green", 1}},
+			children:    []client.Object{testPod("a", "Red", 5), testPod("b", "Red", 0)},
+			wantByChild: map[string]string{"a": "Green", "b": "Red"},
+			wantSurplus: map[string]bool{},
+		},
+		{
+			name:        "children beyond the targets are surplus and keep their color",
+			targets:     []colorTarget{{"Red", 1}},
+			children:    []client.Object{testPod("a", "Red", 0), testPod("b", "Blue", 1)},
+			wantByChild: map[string]string{"a": "Red", "b": "Blue"},
+			wantSurplus: map[string]bool{"b": true},
+		},
+		{
+			name:           "new children take the colors left round robin",
+			targets:        []colorTarget{{"Red", 2}, {"Green", 2}, {"Blue", 1}},
+			children:       []client.Object{testPod("a", "Green", 0)},
+			wantByChild:    map[string]string{"a": "Green"},
+			wantSurplus:    map[string]bool{},
+			wantUnassigned: []string{"Red", "Green", "Blue", "Red"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := assignColors(tt.targets, tt.children)
+			if !reflect.DeepEqual(got.byChild, tt.wantByChild) {
+				t.Errorf("byChild = %v, want %v", got.byChild, tt.wantByChild)
+			}
+			if !reflect.DeepEqual(got.surplus, tt.wantSurplus) {
+				t.Errorf("surplus = %v, want %v", got.surplus, tt.wantSurplus)
+			}
+			if !reflect.DeepEqual(got.unassigned, tt.wantUnassigned) {
+				t.Errorf("unassigned = %v, want %v", got.unassigned, tt.wantUnassigned)
+			}
+		})
+	}
+}
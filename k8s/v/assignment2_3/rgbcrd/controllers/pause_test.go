@@ -0,0 +1,102 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// protectedPod returns a Pod child carrying the protection annotation set
+// to value, none when value is empty.
+func protectedPod(name string, value string) *corev1.Pod {
+	pod := testPod(name, "Red", 0)
+	if value != "" {
+		pod.Annotations = map[string]string{kdv1.ProtectedAnnotation: value}
+	}
+	return pod
+}
+
+func TestIsProtected(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"", false},
+		{"true", true},
+		{"false", false},
+		{"yes", false},
+	}
+	for _, tt := range tests {
+		if got := isProtected(protectedPod("a", tt.value)); got != tt.want {
+			t.Errorf("isProtected(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestUnprotected(t *testing.T) {
+	children := []client.Object{
+		protectedPod("a", ""),
+		protectedPod("b", "true"),
+		protectedPod("c", "false"),
+		protectedPod("d", "true"),
+	}
+	var got []string
+	for _, child := range unprotected(children) {
+		got = append(got, child.GetName())
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unprotected() = %v, want %v in order", got, want)
+	}
+}
+
+func TestReportPaused(t *testing.T) {
+	now := metav1.Now()
+	ready := testPod("ready", "Red", 0)
+	ready.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	starting := testPod("starting", "Red", 1)
+	deleting := testPod("deleting", "Red", 2)
+	deleting.DeletionTimestamp = &now
+	finished := testPod("finished", "Red", 3)
+	finished.Status.Phase = corev1.PodSucceeded
+
+	rgb := testRGB()
+	rgb.Spec.Paused = true
+	r := newTestReconciler()
+	result, err := r.reportPaused(logr.Discard(), podManager{}, rgb, []client.Object{ready, starting, deleting, finished})
+	if err != nil || result.Requeue || result.RequeueAfter != 0 {
+		t.Fatalf("reportPaused() = %+v, %v, want no requeue", result, err)
+	}
+	if rgb.Status.Replicas != 2 || rgb.Status.ReadyReplicas != 1 {
+		t.Errorf("replicas = %d, ready %d, want 2, 1", rgb.Status.Replicas, rgb.Status.ReadyReplicas)
+	}
+	if !meta.IsStatusConditionTrue(rgb.Status.Conditions, kdv1.ConditionPaused) {
+		t.Errorf("Paused condition not True: %v", rgb.Status.Conditions)
+	}
+	progressing := meta.FindStatusCondition(rgb.Status.Conditions, kdv1.ConditionProgressing)
+	if progressing == nil || progressing.Status != metav1.ConditionFalse || progressing.Reason != kdv1.ReasonPaused {
+		t.Errorf("Progressing = %+v, want False with reason %s", progressing, kdv1.ReasonPaused)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// desiredCount returns how many children the RGB resource keeps: spec.count,
+// or the minimum of the RGBPolicies when the scale subresource, which the
+// webhook does not see, took it lower.
+func (r *RGBResourceManagerReconciler) desiredCount(ctx context.Context, rgb_resource *kdv1.RGBResourceManager) (int, error) {
+	limits, err := kdv1.LimitsFor(ctx, r, childNamespace(rgb_resource))
+	if err != nil {
+		return 0, err
+	}
+	desired := int(rgb_resource.Spec.Count)
+	if min := int(limits.MinCount); desired < min {
+		desired = min
+	}
+	return desired, nil
+}
+
+// policyRoom returns how many children the RGB resource may have under the
+// RGBPolicies of the children's namespace, and the limits it breaks. The webhook
+// already checked the spec, but the scale subresource and policies changed
+// since then get past it. A disallowed kind or image leaves no room at all.
+func (r *RGBResourceManagerReconciler) policyRoom(ctx context.Context, rgb_resource *kdv1.RGBResourceManager, desired int) (int, field.ErrorList, error) {
+	limits, err := kdv1.LimitsFor(ctx, r, childNamespace(rgb_resource))
+	if err != nil {
+		return 0, nil, err
+	}
+	others := int32(0)
+	if limits.MaxChildren != nil {
+		if others, err = kdv1.NamespaceCount(ctx, r, childNamespace(rgb_resource), client.ObjectKeyFromObject(rgb_resource)); err != nil {
+			return 0, nil, err
+		}
+	}
+	images := rgb_resource.Images()
+	violations := limits.Check(rgb_resource, images, others)
+
+	room := desired
+	if max := int(limits.MaxCount); room > max {
+		room = max
+	}
+	if limits.MaxChildren != nil {
+		if quota := int(*limits.MaxChildren - others); room > quota {
+			room = quota
+		}
+	}
+	if !limits.KindAllowed(rgb_resource.Spec.Kind) {
+		room = 0
+	}
+	for _, image := range images {
+		if !limits.ImageAllowed(image) {
+			room = 0
+		}
+	}
+	if room < 0 {
+		room = 0
+	}
+	return room, violations, nil
+}
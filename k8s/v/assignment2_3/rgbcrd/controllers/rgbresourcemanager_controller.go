@@ -18,19 +18,43 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/google/uuid"
-	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	kdv1 "kb.example.com/rgbcrd/api/v1"
 )
@@ -38,19 +62,51 @@ import (
 // RGBResourceManagerReconciler reconciles a RGBResourceManager object
 type RGBResourceManagerReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme   *runtime.Scheme
+	Log      logr.Logger
+	Recorder record.EventRecorder
+
+	// MaxConcurrentReconciles is how many RGB resources are reconciled at
+	// once, one when unset.
+	MaxConcurrentReconciles int
+
+	// Children of kinds only known at runtime are watched once the first
+	// RGB resource asks for them, through the controller built in
+	// SetupWithManager.
+	controller controller.Controller
+	cache      cache.Cache
+	mapper     meta.RESTMapper
+	watchesMu  sync.Mutex
+	watches    map[schema.GroupVersionKind]bool
+
+	// Creates and deletes not yet seen in the cache, per RGB resource.
+	expectations *expectations
+
+	// apiReader reads past the cache, before adopting children.
+	apiReader client.Reader
 }
 
 //+kubebuilder:rbac:groups=kd.kb.example.com,resources=rgbresourcemanagers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=kd.kb.example.com,resources=rgbresourcemanagers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=kd.kb.example.com,resources=rgbresourcemanagers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=kd.kb.example.com,resources=rgbcolorsets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kd.kb.example.com,resources=rgbpolicies,verbs=get;list;watch
 
-// Additional rbac rules so we can manage Pods and deployments.
+// Additional rbac rules so we can manage every supported child kind.
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get
+//+kubebuilder:rbac:groups=apps,resources=statefulsets;daemonsets;replicasets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets/status;daemonsets/status;replicasets/status,verbs=get
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs/status,verbs=get
+
+// What reconciles and cleanups do is reported through events.
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Children may be placed in a target namespace, which has to exist.
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -67,159 +123,923 @@ func (r *RGBResourceManagerReconciler) Reconcile(ctx context.Context, req ctrl.R
 	// Logic
 	// RGB Resource will create resources as per spec and once child resources (pod or deployment)
 	// is created and ready, we will mark RBG resource as ready.
+	// Whatever the outcome, the status conditions are refreshed on every pass.
 
 	// Get the RGB Resource.
 	var rgb_resource kdv1.RGBResourceManager
 	err := r.Get(ctx, req.NamespacedName, &rgb_resource)
 	if err != nil {
+		if apierrors.IsNotFound(err) {
+			r.expectations.forget(req.NamespacedName)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 	log.Info("Reconciling RGB", "Color", rgb_resource.Spec.Color)
 
-	if rgb_resource.Spec.Kind == kdv1.RGBSupportedKind(kdv1.PodRc) {
-		// Managing PODs
-		var childPods corev1.PodList
-		// err := r.List(ctx, &childPods,
-		// 	client.InNamespace(req.Namespace),
-		// 	client.MatchingLabels(map[string]string{"app": "rgb"}));
-		err := r.List(ctx, &childPods,
-			client.InNamespace(req.Namespace), client.MatchingFields{podOwnerKey: req.Name})
-		if err != nil {
-
-			log.Error(err, "unable to list child pods")
+	if !rgb_resource.DeletionTimestamp.IsZero() {
+		return r.finalizeRGB(ctx, log, &rgb_resource)
+	}
+	// The finalizer lets the deletion policy run before the RGB resource
+	// goes. The garbage collector alone would delete every child it owns and
+	// none in another namespace.
+	if !controllerutil.ContainsFinalizer(&rgb_resource, cleanupFinalizer) {
+		controllerutil.AddFinalizer(&rgb_resource, cleanupFinalizer)
+		if err := r.Update(ctx, &rgb_resource); err != nil {
 			return ctrl.Result{}, err
 		}
+	}
 
-		count := len(childPods.Items)
-		log.Info("Reconciling RGB", "Kind", "Pod", "Count", count)
+	original := rgb_resource.DeepCopy()
+	result, err := r.reconcileChildren(ctx, log, &rgb_resource)
+	// A transition only counts once it is stored, the retry after a failed
+	// update would report it again.
+	if statusErr := r.updateRGBStatus(ctx, log, original, &rgb_resource); statusErr != nil {
+		if err == nil {
+			err = statusErr
+		}
+		return result, err
+	}
+	r.conditionEvents(original, &rgb_resource)
+	observeReady(original, &rgb_resource)
+	return result, err
+}
 
-		// Reconcile to ensure spec
-		if count == int(rgb_resource.Spec.Count) {
-			// Final state achieved, mark rgb as ready
-			return r.markRGBReady(ctx, log, &rgb_resource)
-		} else if count < int(rgb_resource.Spec.Count) {
-			// Total Pods less then expected, create
-			newCntToCreate := int(rgb_resource.Spec.Count) - count
-			log.Info("Reconciling RGB", "operation", "create-pod", "count", newCntToCreate)
-			for i := 0; i < newCntToCreate; i++ {
-
-				name := rgb_resource.Name + "-" + uuid.New().String()
-				d := createPodObj("default", name, "app", "rgb")
-				d.Labels["color"] = string(rgb_resource.Spec.Color)
-				// Set owner reference
-				if err := ctrl.SetControllerReference(&rgb_resource, d, r.Scheme); err != nil {
-					return ctrl.Result{}, err
-				}
-				log.Info("Reconciling RGB", "operation", "create-pod", "Name", name)
-				err = r.Create(ctx, d, &client.CreateOptions{})
-				if err != nil {
-					// Requeue
-					log.Info("Reconciling RGB", "operation", "create-pod", "Failed", name)
-					return ctrl.Result{}, err
-				}
-				log.Info("Reconciling RGB", "operation", "create-pod", "Success", name)
+// reconcileChildren creates or deletes children until their number matches
+// Spec.Count, within the RGBPolicy bounds, and records the outcome in the status conditions.
+func (r *RGBResourceManagerReconciler) reconcileChildren(ctx context.Context, log logr.Logger, rgb_resource *kdv1.RGBResourceManager) (ctrl.Result, error) {
+	selector, err := childSelector(rgb_resource)
+	if err != nil {
+		markRGBDegraded(rgb_resource, kdv1.ReasonInvalidSelector, err.Error())
+		return ctrl.Result{}, nil
+	}
+	// Scale selectors only reach pods in the namespace of the RGB resource,
+	// one would match none of the children in another.
+	rgb_resource.Status.Selector = ""
+	if !isCrossNamespace(rgb_resource) {
+		rgb_resource.Status.Selector = selector.String()
+	}
+	namespace := childNamespace(rgb_resource)
+	template := podTemplate(rgb_resource)
+	hash := templateHash(template)
+
+	// Leave the children alone while a color is unknown, rather than
+	// spreading it or undoing it.
+	allowed, err := kdv1.AllowedColors(ctx, r)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if unknown := unknownColors(rgb_resource, allowed); len(unknown) > 0 {
+		markRGBDegraded(rgb_resource, kdv1.ReasonUnknownColor,
+			fmt.Sprintf("not defined by any RGBColorSet: %s", strings.Join(unknown, ", ")))
+		return ctrl.Result{}, nil
+	}
+
+	m, err := r.managerFor(rgb_resource)
+	if err != nil {
+		// Retrying can not fix the spec, so report it instead of requeueing,
+		// unless the kind may still be installed.
+		log.Error(err, "Reconciling RGB", "Kind", rgb_resource.Spec.Kind)
+		switch {
+		case meta.IsNoMatchError(err):
+			markRGBDegraded(rgb_resource, kdv1.ReasonKindNotFound,
+				fmt.Sprintf("kind %q is not served by the cluster", rgb_resource.ChildGroupVersionKind()))
+			return ctrl.Result{RequeueAfter: time.Minute}, nil
+		case errors.Is(err, errUnsupportedKind), errors.Is(err, errClusterScoped):
+			markRGBDegraded(rgb_resource, kdv1.ReasonUnsupportedKind,
+				fmt.Sprintf("kind %q is not supported: %v", rgb_resource.Spec.Kind, err))
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if um, ok := m.(*unstructuredManager); ok {
+		hash = um.hash
+	}
+	op := m.op()
+	kind := string(rgb_resource.Spec.Kind)
+	events := &childEvents{}
+	defer r.flushEvents(rgb_resource, op, events)
+
+	if isCrossNamespace(rgb_resource) {
+		var ns corev1.Namespace
+		if err := r.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
 			}
-		} else {
-			newCntToDelete := count - int(rgb_resource.Spec.Count)
-			log.Info("Reconciling RGB", "operation", "delete-pod", "count", newCntToDelete)
-			for i := 0; i < newCntToDelete; i++ {
-				log.Info("Reconciling RGB", "operation", "delete-pod", "Name", childPods.Items[i].Name)
-				err = r.Delete(ctx, &childPods.Items[i], &client.DeleteOptions{})
-				if err != nil {
-					log.Info("Reconciling RGB", "operation", "delete-pod", "Failed", childPods.Items[i].Name)
-					return ctrl.Result{}, err
-				}
-				log.Info("Reconciling RGB", "operation", "delete-pod", "Success", childPods.Items[i].Name)
+			markRGBDegraded(rgb_resource, kdv1.ReasonNamespaceNotFound,
+				fmt.Sprintf("target namespace %q does not exist", namespace))
+			return ctrl.Result{RequeueAfter: time.Minute}, nil
+		}
+	}
+
+	if err := r.watchKind(ctx, m); err != nil {
+		if errors.Is(err, errNoWatchAccess) {
+			// Retrying can not help until someone grants the access.
+			markRGBDegraded(rgb_resource, kdv1.ReasonAccessDenied,
+				fmt.Sprintf("kind %q can not be watched: %v", rgb_resource.ChildGroupVersionKind(), err))
+			return ctrl.Result{RequeueAfter: time.Minute}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	all, err := r.listChildren(ctx, m, rgb_resource)
+	if err != nil {
+		log.Error(err, "unable to list child "+op+"s")
+		markRGBDegraded(rgb_resource, kdv1.ReasonListFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+	protected := 0
+	for _, child := range all {
+		if child.GetDeletionTimestamp() == nil && isProtected(child) {
+			protected++
+		}
+	}
+	rgb_resource.Status.ProtectedReplicas = int32(protected)
+	if rgb_resource.Spec.Paused {
+		return r.reportPaused(log, m, rgb_resource, all)
+	}
+	setCondition(rgb_resource, kdv1.ConditionPaused, metav1.ConditionFalse, kdv1.ReasonAsExpected, "")
+
+	all, err = r.claimChildren(ctx, log, m, rgb_resource, selector, all, events)
+	if err != nil {
+		markRGBDegraded(rgb_resource, kdv1.ReasonClaimFailed, err.Error())
+		return ctrl.Result{}, err
+	}
+
+	var children []client.Object
+	for _, child := range all {
+		if child.GetDeletionTimestamp() != nil {
+			// Already going away, a replacement is created below.
+			continue
+		}
+		if m.isTerminal(child) {
+			if isProtected(child) {
+				// Kept for whoever protected it, but replaced all the same.
+				continue
+			}
+			// A finished child never serves again, replace it.
+			log.Info("Reconciling RGB", "operation", "delete-"+op, "Terminated", child.GetName())
+			err := client.IgnoreNotFound(m.delete(ctx, r, child))
+			countOperation(kind, "terminate", err)
+			if err != nil {
+				log.Info("Reconciling RGB", "operation", "delete-"+op, "Failed", child.GetName())
+				markRGBDegraded(rgb_resource, kdv1.ReasonDeleteFailed, err.Error())
+				return ctrl.Result{}, err
 			}
+			events.add(eventTerminated, child.GetName())
+			continue
 		}
+		children = append(children, child)
+	}
 
-	} else if rgb_resource.Spec.Kind == kdv1.RGBSupportedKind(kdv1.DeploymentRc) {
-		// Managing deployments.
-		var childDeployments appsv1.DeploymentList
-		if err := r.List(ctx, &childDeployments,
-			client.InNamespace(req.Namespace),
-			client.MatchingLabels(map[string]string{"app": "rgb"})); err != nil {
+	desired, err := r.desiredCount(ctx, rgb_resource)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 
-			log.Error(err, "unable to list child deployments")
+	// Bring existing children to their color and the current template.
+	targets := colorTargets(rgb_resource, desired)
+	colors := assignColors(targets, children)
+	for i, child := range children {
+		color := colors.byChild[child.GetName()]
+		oldColor := child.GetLabels()[colorLabel]
+		if isAdopted(child) {
+			// Replaced below, whatever it runs.
+			continue
+		}
+		retemplated := false
+		if !isTemplateCurrent(child, rgb_resource, hash) {
+			if !m.retemplate(child, template) {
+				// Replaced below, applying the current template would
+				// change what can not be updated.
+				continue
+			}
+			retemplated = true
+		}
+		recolored := false
+		if !colors.surplus[child.GetName()] {
+			recolored = m.recolor(child, color)
+		}
+		if !recolored && !retemplated {
+			continue
+		}
+		log.Info("Reconciling RGB", "operation", "update-"+op, "Name", child.GetName(), "Color", color)
+		// Apply the child whole under fieldManager, as drift repair does.
+		applied, err := r.desiredChild(m, rgb_resource, child, template)
+		if err == nil {
+			if err = applyChild(ctx, r.Client, applied); err == nil {
+				children[i] = applied
+			}
+		}
+		if recolored {
+			countOperation(kind, "recolor", err)
+		}
+		if retemplated {
+			countOperation(kind, "update", err)
+		}
+		if err != nil {
+			log.Info("Reconciling RGB", "operation", "update-"+op, "Failed", child.GetName())
+			markRGBDegraded(rgb_resource, kdv1.ReasonUpdateFailed, err.Error())
 			return ctrl.Result{}, err
 		}
+		log.Info("Reconciling RGB", "operation", "update-"+op, "Success", child.GetName())
+		if recolored {
+			events.add(eventRecolored, child.GetName())
+			r.childEvent(child, rgb_resource, eventRecolored, fmt.Sprintf("Recolored from %s to %s", oldColor, color))
+		}
+		if retemplated {
+			events.add(eventUpdated, child.GetName())
+			r.childEvent(child, rgb_resource, eventUpdated, "Updated to the current template")
+		}
+	}
 
-		count := len(childDeployments.Items)
-		log.Info("Reconciling RGB", "Deployments", count)
+	count := len(children)
+	ready := 0
+	updated := 0
+	// Children the kind could not update in place are stale and replaced.
+	var stale []client.Object
+	staleTemplates := 0
+	for _, child := range children {
+		if m.isReady(child) {
+			ready++
+		}
+		templateCurrent := isTemplateCurrent(child, rgb_resource, hash)
+		if !templateCurrent {
+			staleTemplates++
+		}
+		color := colors.byChild[child.GetName()]
+		if !templateCurrent || child.GetLabels()[colorLabel] != color {
+			stale = append(stale, child)
+		} else if m.isUpToDate(child, color) {
+			updated++
+		}
+	}
+	rgb_resource.Status.Replicas = int32(count)
+	rgb_resource.Status.ReadyReplicas = int32(ready)
+	rgb_resource.Status.UpdatedReplicas = int32(updated)
+	rgb_resource.Status.Colors = colorStatus(targets, children, m.isReady)
+	active, err := r.activeReferences(children)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	rgb_resource.Status.Active = active
+	log.Info("Reconciling RGB", "Kind", rgb_resource.Spec.Kind, "Count", count, "Ready", ready)
+
+	// Set back what others changed on the children that are otherwise as
+	// they should be.
+	isStale := map[string]bool{}
+	for _, child := range stale {
+		isStale[child.GetName()] = true
+	}
+	var current []client.Object
+	for _, child := range children {
+		if !isStale[child.GetName()] && !colors.surplus[child.GetName()] {
+			current = append(current, child)
+		}
+	}
+	// A failed repair is retried, but does not hold up scaling.
+	repairs, repairErr := r.repairDrift(ctx, log, m, rgb_resource, current, template, events)
+	markDrift(rgb_resource, repairs)
+	if repairErr != nil {
+		markRGBDegraded(rgb_resource, kdv1.ReasonRepairFailed, repairErr.Error())
+	}
 
-		if count == int(rgb_resource.Spec.Count) {
+	// Counting on a cache that has not seen the last creates and deletes
+	// would create or delete too many. The events bring the RGB resource
+	// back once it has.
+	key := types.NamespacedName{Namespace: rgb_resource.Namespace, Name: rgb_resource.Name}
+	if ok, creates, deletes := r.expectations.satisfied(key); !ok {
+		log.Info("Reconciling RGB", "operation", "wait-"+op, "Creates", creates, "Deletes", deletes)
+		return ctrl.Result{RequeueAfter: expectationsTimeout}, nil
+	}
+
+	// Reconcile to ensure spec
+	if count == desired {
+		if ready == desired {
 			// Final state achieved, mark rgb as ready
-			return r.markRGBReady(ctx, log, &rgb_resource)
-		} else if count < int(rgb_resource.Spec.Count) {
-			// Total Deployments less then expected, create
-			newCntToCreate := int(rgb_resource.Spec.Count) - count
-			log.Info("Reconciling RGB", "operation", "create-deployment", "count", newCntToCreate)
-			for i := 0; i < newCntToCreate; i++ {
-
-				name := rgb_resource.Name + "-" + uuid.New().String()
-				d := createDeploymentObj("default", name, 1, "app", "rgb")
-				d.Labels["color"] = string(rgb_resource.Spec.Color)
-				// Set owner reference
-				if err := ctrl.SetControllerReference(&rgb_resource, d, r.Scheme); err != nil {
-					return ctrl.Result{}, err
+			markRGBReady(rgb_resource, ready)
+			if updated < count {
+				// Still serving, but a new color or template is rolling out.
+				reason := kdv1.ReasonRecoloring
+				if staleTemplates > 0 || rgb_resource.Spec.Template != nil && updated+len(stale) < count {
+					reason = kdv1.ReasonRollingOut
 				}
-				log.Info("Reconciling RGB", "operation", "create-deployment", "Name", name)
-				err = r.Create(ctx, d, &client.CreateOptions{})
+				setCondition(rgb_resource, kdv1.ConditionProgressing, metav1.ConditionTrue, reason,
+					fmt.Sprintf("%d of %d %s(s) updated", updated, count, op))
+			}
+			if replaceable := unprotected(stale); len(replaceable) > 0 {
+				// Replace one child at a time so the others keep serving.
+				victim := replaceable[0]
+				log.Info("Reconciling RGB", "operation", "replace-"+op, "Name", victim.GetName())
+				r.expectations.expectDeletions(key, victim.GetUID())
+				err := client.IgnoreNotFound(m.delete(ctx, r, victim))
+				countOperation(kind, "replace", err)
 				if err != nil {
-					// Requeue
-					log.Info("Reconciling RGB", "operation", "create-deployment", "Failed", name)
+					r.expectations.deletionObserved(key, victim.GetUID())
+					log.Info("Reconciling RGB", "operation", "replace-"+op, "Failed", victim.GetName())
+					markRGBDegraded(rgb_resource, kdv1.ReasonDeleteFailed, err.Error())
 					return ctrl.Result{}, err
 				}
-				log.Info("Reconciling RGB", "operation", "create-deployment", "Success", name)
+				log.Info("Reconciling RGB", "operation", "replace-"+op, "Success", victim.GetName())
+				events.add(eventReplaced, victim.GetName())
+			} else if len(stale) > 0 {
+				setCondition(rgb_resource, kdv1.ConditionProgressing, metav1.ConditionTrue, kdv1.ReasonProtected,
+					fmt.Sprintf("%d outdated %s(s) are protected and not replaced", len(stale), op))
 			}
-
 		} else {
-			newCntToDelete := count - int(rgb_resource.Spec.Count)
-			log.Info("Reconciling RGB", "operation", "delete-deployment", "count", newCntToDelete)
-			for i := 0; i < newCntToDelete; i++ {
-				log.Info("Reconciling RGB", "operation", "delete-deployment", "Name", childDeployments.Items[i].Name)
-				err = r.Delete(ctx, &childDeployments.Items[i], &client.DeleteOptions{})
-				if err != nil {
-					log.Info("Reconciling RGB", "operation", "delete-deployment", "Failed", childDeployments.Items[i].Name)
-					return ctrl.Result{}, err
-				}
-				log.Info("Reconciling RGB", "operation", "delete-deployment", "Success", childDeployments.Items[i].Name)
+			// Nothing to create or delete, the children just are not serving yet.
+			markRGBProgressing(rgb_resource, kdv1.ReasonWaitingForReady,
+				fmt.Sprintf("%d of %d %s(s) ready", ready, desired, op))
+		}
+	} else if count < desired {
+		// Total children less then expected, create
+		newCntToCreate := desired - count
+		markRGBProgressing(rgb_resource, kdv1.ReasonScalingUp,
+			fmt.Sprintf("creating %d %s(s), %d of %d exist", newCntToCreate, op, count, desired))
+		room, violations, err := r.policyRoom(ctx, rgb_resource, desired)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if room < desired {
+			// Create what the policies leave room for. Room may free up
+			// as other RGB resources of the namespace scale down.
+			log.Info("Reconciling RGB", "operation", "create-"+op, "PolicyRoom", room)
+			markRGBDegraded(rgb_resource, kdv1.ReasonPolicyViolation, violations.ToAggregate().Error())
+			if count >= room {
+				return ctrl.Result{RequeueAfter: time.Minute}, nil
 			}
+			newCntToCreate = room - count
+		}
+		log.Info("Reconciling RGB", "operation", "create-"+op, "count", newCntToCreate)
+		names, err := r.childNames(ctx, m, rgb_resource, namespace, newCntToCreate)
+		if err != nil {
+			markRGBDegraded(rgb_resource, kdv1.ReasonListFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+		newChildren := make([]client.Object, newCntToCreate)
+		for i, name := range names {
+			newLabels := childLabels(rgb_resource)
+			newLabels[colorLabel] = colors.unassigned[i]
+			d := m.build(namespace, name, newLabels, template)
+			if !isCrossNamespace(rgb_resource) && !selector.Matches(labels.Set(d.GetLabels())) {
+				// It would be released as soon as it was created.
+				markRGBDegraded(rgb_resource, kdv1.ReasonInvalidSelector,
+					fmt.Sprintf("selector %q does not match the labels of new %s(s)", selector, op))
+				return ctrl.Result{}, nil
+			}
+			if err := r.setOwner(rgb_resource, d); err != nil {
+				return ctrl.Result{}, err
+			}
+			newChildren[i] = d
+		}
+
+		// Create in growing batches, so a failure that would hit every
+		// child stops after the first few.
+		r.expectations.expectCreations(key, newCntToCreate)
+		created := make([]bool, newCntToCreate)
+		successes, err := slowStartBatch(newCntToCreate, slowStartInitialBatchSize, func(i int) error {
+			name := newChildren[i].GetName()
+			log.Info("Reconciling RGB", "operation", "create-"+op, "Name", name)
+			err := m.create(ctx, r.Client, newChildren[i])
+			countOperation(kind, "create", err)
+			if err != nil {
+				log.Info("Reconciling RGB", "operation", "create-"+op, "Failed", name)
+				return err
+			}
+			log.Info("Reconciling RGB", "operation", "create-"+op, "Success", name)
+			created[i] = true
+			return nil
+		})
+		for i, d := range newChildren {
+			if created[i] {
+				events.add(eventCreated, d.GetName())
+			}
+		}
+		if err != nil {
+			// The failed creates and the ones never tried will not show up.
+			r.expectations.creationObserved(key, newCntToCreate-successes)
+			// Requeue
+			markRGBDegraded(rgb_resource, kdv1.ReasonCreateFailed,
+				fmt.Sprintf("created %d of %d %s(s): %v", successes, newCntToCreate, op, err))
+			return ctrl.Result{}, err
 		}
-		// Reconcile to ensure spec
 	} else {
-		return ctrl.Result{}, errors.New("unsupported kind in rgb")
+		newCntToDelete := count - desired
+		markRGBProgressing(rgb_resource, kdv1.ReasonScalingDown,
+			fmt.Sprintf("deleting %d %s(s), %d of %d exist", newCntToDelete, op, count, desired))
+		log.Info("Reconciling RGB", "operation", "delete-"+op, "count", newCntToDelete, "Policy", scaleDownPolicy(rgb_resource))
+		victims, err := r.rankForScaleDown(ctx, m, rgb_resource, children, colors)
+		if err != nil {
+			markRGBDegraded(rgb_resource, kdv1.ReasonListFailed, err.Error())
+			return ctrl.Result{}, err
+		}
+		victims = unprotected(victims)
+		if len(victims) < newCntToDelete {
+			// Protected children stay, even beyond spec.count.
+			markRGBProgressing(rgb_resource, kdv1.ReasonProtected,
+				fmt.Sprintf("deleting %d %s(s), %d of %d exist, %d protected", len(victims), op, count, desired, count-len(victims)))
+			newCntToDelete = len(victims)
+		}
+		for i := 0; i < newCntToDelete; i++ {
+			log.Info("Reconciling RGB", "operation", "delete-"+op, "Name", victims[i].GetName())
+			r.expectations.expectDeletions(key, victims[i].GetUID())
+			err := m.delete(ctx, r, victims[i])
+			countOperation(kind, "delete", err)
+			if err != nil {
+				r.expectations.deletionObserved(key, victims[i].GetUID())
+				log.Info("Reconciling RGB", "operation", "delete-"+op, "Failed", victims[i].GetName())
+				markRGBDegraded(rgb_resource, kdv1.ReasonDeleteFailed, err.Error())
+				return ctrl.Result{}, err
+			}
+			log.Info("Reconciling RGB", "operation", "delete-"+op, "Success", victims[i].GetName())
+			events.add(eventDeleted, victims[i].GetName())
+		}
+	}
+
+	if desired > int(rgb_resource.Spec.Count) {
+		markRGBDegraded(rgb_resource, kdv1.ReasonPolicyViolation,
+			fmt.Sprintf("spec.count %d is below the RGBPolicy minimum, keeping %d %s(s)", rgb_resource.Spec.Count, desired, op))
+	}
+	if repairErr != nil {
+		// Marking the children ready cleared it.
+		markRGBDegraded(rgb_resource, kdv1.ReasonRepairFailed, repairErr.Error())
+		return ctrl.Result{}, repairErr
 	}
 
+	// Come back to look for drift even when no child changes.
+	return ctrl.Result{RequeueAfter: driftInterval}, nil
+}
+
+// reportPaused keeps the status of a paused RGB resource up to date without
+// touching its children.
+func (r *RGBResourceManagerReconciler) reportPaused(log logr.Logger, m childManager, rgb_resource *kdv1.RGBResourceManager, all []client.Object) (ctrl.Result, error) {
+	count := 0
+	ready := 0
+	for _, child := range all {
+		if child.GetDeletionTimestamp() != nil || m.isTerminal(child) {
+			continue
+		}
+		count++
+		if m.isReady(child) {
+			ready++
+		}
+	}
+	rgb_resource.Status.Replicas = int32(count)
+	rgb_resource.Status.ReadyReplicas = int32(ready)
+	log.Info("Reconciling RGB", "operation", "paused", "Count", count, "Ready", ready)
+
+	setCondition(rgb_resource, kdv1.ConditionPaused, metav1.ConditionTrue, kdv1.ReasonPaused,
+		"spec.paused is set, the children are left as they are")
+	setCondition(rgb_resource, kdv1.ConditionProgressing, metav1.ConditionFalse, kdv1.ReasonPaused,
+		fmt.Sprintf("%d of %d %s(s) exist, %d ready", count, rgb_resource.Spec.Count, m.op(), ready))
 	return ctrl.Result{}, nil
 }
 
+// listChildren returns every child of the RGB resource, including the ones
+// already being deleted.
+func (r *RGBResourceManagerReconciler) listChildren(ctx context.Context, m childManager, rgb_resource *kdv1.RGBResourceManager) ([]client.Object, error) {
+	opts := []client.ListOption{client.InNamespace(childNamespace(rgb_resource))}
+	if isCrossNamespace(rgb_resource) || !m.indexed() {
+		opts = append(opts, client.MatchingLabels{
+			rgbNameLabel:      rgb_resource.Name,
+			rgbNamespaceLabel: rgb_resource.Namespace,
+		})
+	} else {
+		opts = append(opts, client.MatchingFields{ownerKey: rgb_resource.Name})
+	}
+	children, err := m.list(ctx, r, opts...)
+	if err != nil || !isCrossNamespace(rgb_resource) && m.indexed() {
+		return children, err
+	}
+
+	// Labels can be copied by anyone who may write them, only the owner
+	// reference or owner UID is proof of ownership.
+	owned := children[:0]
+	for _, child := range children {
+		if isOwnedBy(child, rgb_resource) {
+			owned = append(owned, child)
+		}
+	}
+	return owned, nil
+}
+
+// setOwner ties a new child to the RGB resource by a controller reference,
+// or in another namespace, where those are not allowed, by the owner UID
+// annotation.
+func (r *RGBResourceManagerReconciler) setOwner(rgb_resource *kdv1.RGBResourceManager, obj client.Object) error {
+	if isCrossNamespace(rgb_resource) {
+		obj.SetAnnotations(mergeLabels(obj.GetAnnotations(), map[string]string{
+			ownerUIDAnnotation: string(rgb_resource.UID),
+		}))
+		return nil
+	}
+	return ctrl.SetControllerReference(rgb_resource, obj, r.Scheme)
+}
+
+// isOwnedBy reports whether the RGB resource set itself as owner of child,
+// see setOwner.
+func isOwnedBy(child client.Object, rgb_resource *kdv1.RGBResourceManager) bool {
+	if isCrossNamespace(rgb_resource) {
+		return child.GetAnnotations()[ownerUIDAnnotation] == string(rgb_resource.UID)
+	}
+	return metav1.IsControlledBy(child, rgb_resource)
+}
+
+// errUnsupportedKind is returned for a kind without a built-in manager when
+// the RGB resource has no manifest either.
+var errUnsupportedKind = errors.New("unsupported kind in rgb")
+
+// managerFor returns the manager of the children of the RGB resource: the
+// built-in one for its kind, or a generic one when it has a manifest.
+func (r *RGBResourceManagerReconciler) managerFor(rgb_resource *kdv1.RGBResourceManager) (childManager, error) {
+	if rgb_resource.Spec.Manifest != nil {
+		return newUnstructuredManager(rgb_resource, r.mapper)
+	}
+	if m := childManagerFor(rgb_resource.Spec.Kind); m != nil {
+		return m, nil
+	}
+	return nil, errUnsupportedKind
+}
+
+// watchKind starts watching the children of a kind only known at runtime.
+// Built-in kinds are watched from the start. Starting a watch on a running
+// controller waits for the informer to sync, forever if the manager may not
+// list the kind, so access is checked and the informer synced here first,
+// within watchSyncTimeout and without holding watchesMu.
+func (r *RGBResourceManagerReconciler) watchKind(ctx context.Context, m childManager) error {
+	um, ok := m.(*unstructuredManager)
+	if !ok {
+		return nil
+	}
+	r.watchesMu.Lock()
+	watched := r.watches[um.gvk]
+	r.watchesMu.Unlock()
+	if watched {
+		return nil
+	}
+
+	if err := r.checkWatchAccess(ctx, um); err != nil {
+		return err
+	}
+	syncCtx, cancel := context.WithTimeout(ctx, watchSyncTimeout)
+	defer cancel()
+	if _, err := r.cache.GetInformer(syncCtx, um.object()); err != nil {
+		return err
+	}
+
+	r.watchesMu.Lock()
+	defer r.watchesMu.Unlock()
+	if r.watches[um.gvk] {
+		return nil
+	}
+	r.Log.Info("Watching children", "GroupVersionKind", um.gvk)
+	// One registration, so a failure leaves no handler behind to be added
+	// twice by the retry.
+	if err := r.controller.Watch(&source.Kind{Type: um.object()}, childHandlers{
+		&handler.EnqueueRequestForOwner{
+			OwnerType:    &kdv1.RGBResourceManager{},
+			IsController: true,
+		},
+		handler.EnqueueRequestsFromMapFunc(mapLabeledChild),
+		handler.EnqueueRequestsFromMapFunc(r.mapOrphan),
+		&expectationsHandler{expectations: r.expectations},
+	}); err != nil {
+		return err
+	}
+	r.watches[um.gvk] = true
+	return nil
+}
+
+// errNoWatchAccess is returned by watchKind when the manager may not list
+// or watch the kind of a manifest.
+var errNoWatchAccess = errors.New("no access")
+
+// checkWatchAccess asks the API server whether the manager may list and
+// watch the kind across all namespaces, as its informers do.
+func (r *RGBResourceManagerReconciler) checkWatchAccess(ctx context.Context, um *unstructuredManager) error {
+	mapping, err := r.mapper.RESTMapping(um.gvk.GroupKind(), um.gvk.Version)
+	if err != nil {
+		return err
+	}
+	for _, verb := range []string{"list", "watch"} {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:     verb,
+					Group:    mapping.Resource.Group,
+					Version:  mapping.Resource.Version,
+					Resource: mapping.Resource.Resource,
+				},
+			},
+		}
+		if err := r.Create(ctx, review); err != nil {
+			return err
+		}
+		if !review.Status.Allowed {
+			return fmt.Errorf("%w: the manager may not %s %s", errNoWatchAccess, verb, mapping.Resource.Resource)
+		}
+	}
+	return nil
+}
+
+// childHandlers passes every event on to each of its handlers in turn.
+type childHandlers []handler.EventHandler
+
+// InjectFunc hands the controller's scheme and mapper on to the handlers.
+func (h childHandlers) InjectFunc(f inject.Func) error {
+	for _, eh := range h {
+		if err := f(eh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h childHandlers) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	for _, eh := range h {
+		eh.Create(e, q)
+	}
+}
+
+func (h childHandlers) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	for _, eh := range h {
+		eh.Update(e, q)
+	}
+}
+
+func (h childHandlers) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	for _, eh := range h {
+		eh.Delete(e, q)
+	}
+}
+
+func (h childHandlers) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	for _, eh := range h {
+		eh.Generic(e, q)
+	}
+}
+
+// finalizeRGB applies the deletion policy to the children before letting the
+// RGB resource go. Progress is reported in the status until it is gone.
+func (r *RGBResourceManagerReconciler) finalizeRGB(ctx context.Context, log logr.Logger, rgb_resource *kdv1.RGBResourceManager) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(rgb_resource, cleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	original := rgb_resource.DeepCopy()
+	remaining, err := r.cleanupChildren(ctx, log, rgb_resource)
+	if err == nil && len(remaining) == 0 {
+		log.Info("Reconciling RGB", "operation", "cleanup", "Success", rgb_resource.Name)
+		r.Recorder.Eventf(rgb_resource, corev1.EventTypeNormal, kdv1.ReasonCleanupComplete,
+			"Cleaned up children, deletion policy %s", deletionPolicy(rgb_resource))
+		controllerutil.RemoveFinalizer(rgb_resource, cleanupFinalizer)
+		return ctrl.Result{}, r.Update(ctx, rgb_resource)
+	}
+
+	result := ctrl.Result{RequeueAfter: 5 * time.Second}
+	if err != nil {
+		markRGBDegraded(rgb_resource, kdv1.ReasonCleanupFailed, err.Error())
+	} else {
+		message := fmt.Sprintf("waiting for %d child(ren) to be deleted", len(remaining))
+		setCondition(rgb_resource, kdv1.ConditionProgressing, metav1.ConditionTrue, kdv1.ReasonCleaningUp, message)
+		// Children with finalizers of their own can hold up the cleanup for
+		// good, name them so whoever is waiting knows where to look.
+		if waited := time.Since(rgb_resource.DeletionTimestamp.Time); waited > cleanupTimeout {
+			message = fmt.Sprintf("still waiting after %s for: %s", waited.Round(time.Second), strings.Join(remaining, ", "))
+			markRGBDegraded(rgb_resource, kdv1.ReasonCleanupStuck, message)
+			r.Recorder.Event(rgb_resource, corev1.EventTypeWarning, kdv1.ReasonCleanupStuck, message)
+			result.RequeueAfter = time.Minute
+		}
+		log.Info("Reconciling RGB", "operation", "cleanup", "Remaining", len(remaining))
+	}
+	if statusErr := r.updateRGBStatus(ctx, log, original, rgb_resource); statusErr != nil && err == nil {
+		err = statusErr
+	}
+	return result, err
+}
+
+// cleanupChildren applies the deletion policy of the RGB resource to its
+// children and returns the names of the ones still to go.
+func (r *RGBResourceManagerReconciler) cleanupChildren(ctx context.Context, log logr.Logger, rgb_resource *kdv1.RGBResourceManager) ([]string, error) {
+	m, err := r.managerFor(rgb_resource)
+	if err != nil {
+		if !meta.IsNoMatchError(err) && !errors.Is(err, errUnsupportedKind) && !errors.Is(err, errClusterScoped) {
+			return nil, err
+		}
+		// Nothing of an unsupported kind was ever created, and the children
+		// of a kind no longer served went with it.
+		return nil, nil
+	}
+	children, err := r.listChildren(ctx, m, rgb_resource)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := deletionPolicy(rgb_resource)
+	if policy != kdv1.DeletionPolicyDelete {
+		op := "release-" + m.op()
+		for _, child := range children {
+			if child.GetDeletionTimestamp() != nil {
+				continue
+			}
+			log.Info("Reconciling RGB", "operation", op, "Name", child.GetName(), "Policy", policy)
+			patch := client.MergeFrom(child.DeepCopyObject().(client.Object))
+			releaseChild(child, rgb_resource, policy)
+			err := client.IgnoreNotFound(r.Patch(ctx, child, patch))
+			countOperation(string(rgb_resource.Spec.Kind), "release", err)
+			if err != nil {
+				log.Info("Reconciling RGB", "operation", op, "Failed", child.GetName())
+				return nil, err
+			}
+		}
+		if len(children) > 0 {
+			r.Recorder.Eventf(rgb_resource, corev1.EventTypeNormal, kdv1.ReasonCleaningUp,
+				"Released %d %s(s), deletion policy %s", len(children), m.op(), policy)
+		}
+		return nil, nil
+	}
+
+	var remaining []string
+	deleted := 0
+	for _, child := range children {
+		remaining = append(remaining, child.GetName())
+		if child.GetDeletionTimestamp() != nil {
+			continue
+		}
+		log.Info("Reconciling RGB", "operation", "cleanup", "Name", child.GetName())
+		err := client.IgnoreNotFound(m.delete(ctx, r, child))
+		countOperation(string(rgb_resource.Spec.Kind), "cleanup", err)
+		if err != nil {
+			log.Info("Reconciling RGB", "operation", "cleanup", "Failed", child.GetName())
+			return remaining, err
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		r.Recorder.Eventf(rgb_resource, corev1.EventTypeNormal, kdv1.ReasonCleaningUp,
+			"Deleting %d %s(s)", deleted, m.op())
+	}
+	// Keep the finalizer until the deletes are observed.
+	return remaining, nil
+}
+
+// releaseChild removes the owner reference or owner UID of the RGB resource
+// from child, so neither the garbage collector nor the RGB resource touches
+// it again. Orphaned children lose their RGB labels
+// as well.
+func releaseChild(child client.Object, rgb_resource *kdv1.RGBResourceManager, policy kdv1.RGBDeletionPolicy) {
+	var refs []metav1.OwnerReference
+	for _, ref := range child.GetOwnerReferences() {
+		if ref.UID != rgb_resource.UID {
+			refs = append(refs, ref)
+		}
+	}
+	child.SetOwnerReferences(refs)
+	annotations := child.GetAnnotations()
+	delete(annotations, ownerUIDAnnotation)
+	child.SetAnnotations(annotations)
+
+	if policy == kdv1.DeletionPolicyOrphan {
+		objLabels := child.GetLabels()
+		delete(objLabels, rgbNameLabel)
+		delete(objLabels, rgbNamespaceLabel)
+		child.SetLabels(objLabels)
+	}
+}
+
+// deletionPolicy returns the deletion policy of the RGB resource, defaulted
+// for objects created before the field existed.
+func deletionPolicy(rgb_resource *kdv1.RGBResourceManager) kdv1.RGBDeletionPolicy {
+	if rgb_resource.Spec.DeletionPolicy == "" {
+		return kdv1.DeletionPolicyDelete
+	}
+	return rgb_resource.Spec.DeletionPolicy
+}
+
 var (
-	podOwnerKey = ".metadata.controller"
-	apiGVStr    = kdv1.GroupVersion.String()
+	ownerKey = ".metadata.controller"
+	apiGVStr = kdv1.GroupVersion.String()
 )
 
+const (
+	// rgbNameLabel is set to the RGB resource name on every child and every
+	// pod it runs, so children of different RGB resources never mix.
+	rgbNameLabel = "rgb"
+	// rgbNamespaceLabel holds the RGB resource namespace, which together with
+	// rgbNameLabel identifies children placed in another namespace.
+	rgbNamespaceLabel = "rgb-namespace"
+	// childNameLabel ties the pods of a child workload to that workload.
+	childNameLabel = "rgb-child"
+	// colorLabel carries the color of the RGB resource on children and their pods.
+	colorLabel = "color"
+	// templateHashAnnotation records the hash of the pod template a child was
+	// created or last updated from.
+	templateHashAnnotation = "kd.kb.example.com/template-hash"
+	// ownerUIDAnnotation holds the UID of the RGB resource on children in
+	// another namespace. Writing labels there is not enough to forge it, it
+	// takes reading the RGB resource, so it stands in for the owner reference.
+	ownerUIDAnnotation = "kd.kb.example.com/owner-uid"
+
+	// cleanupFinalizer holds back deletion of a RGB resource until its
+	// deletion policy has been applied to the children.
+	cleanupFinalizer = "kd.kb.example.com/cleanup"
+	// cleanupTimeout is how long a cleanup may take before it is reported
+	// as stuck.
+	cleanupTimeout = 5 * time.Minute
+	// watchSyncTimeout bounds the wait for the informer of a kind only known
+	// at runtime to sync.
+	watchSyncTimeout = 30 * time.Second
+)
+
+// childNamespace is the namespace the children of the RGB resource live in.
+func childNamespace(rgb_resource *kdv1.RGBResourceManager) string {
+	return rgb_resource.ChildNamespace()
+}
+
+// isCrossNamespace reports whether children live outside the RGB resource's
+// namespace, where owner references are not allowed.
+func isCrossNamespace(rgb_resource *kdv1.RGBResourceManager) bool {
+	return childNamespace(rgb_resource) != rgb_resource.Namespace
+}
+
+// selectorLabels are the labels every pod run for the RGB resource carries.
+func selectorLabels(rgb_resource *kdv1.RGBResourceManager) map[string]string {
+	return map[string]string{
+		"app":        "rgb",
+		rgbNameLabel: rgb_resource.Name,
+	}
+}
+
+// childLabels are the labels set on every child of the RGB resource.
+func childLabels(rgb_resource *kdv1.RGBResourceManager) map[string]string {
+	childLabels := selectorLabels(rgb_resource)
+	childLabels[rgbNamespaceLabel] = rgb_resource.Namespace
+	return childLabels
+}
+
+// unknownColors returns the colors the RGB resource hands out that are not
+// allowed. Spec.color only counts when the palette leaves children to it.
+func unknownColors(rgb_resource *kdv1.RGBResourceManager, allowed map[kdv1.RGBColor]bool) []string {
+	var unknown []string
+	for _, t := range colorTargets(rgb_resource, int(rgb_resource.Spec.Count)) {
+		if !allowed[kdv1.RGBColor(t.color)] && (t.desired > 0 || len(rgb_resource.Spec.Palette) > 0) {
+			unknown = append(unknown, t.color)
+		}
+	}
+	return unknown
+}
+
+// mapAllRGBs enqueues every RGB resource, any of them may use the colors a
+// RGBColorSet added or removed, or be selected by a changed RGBPolicy.
+func (r *RGBResourceManagerReconciler) mapAllRGBs(obj client.Object) []reconcile.Request {
+	var rgbs kdv1.RGBResourceManagerList
+	if err := r.List(context.Background(), &rgbs); err != nil {
+		r.Log.Error(err, "unable to list RGB resources", "Trigger", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(rgbs.Items))
+	for _, rgb := range rgbs.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: rgb.Namespace, Name: rgb.Name},
+		})
+	}
+	return requests
+}
+
+// mapLabeledChild enqueues the RGB resource named by a child's labels. Owns
+// covers children in the RGB resource's own namespace, this covers the rest.
+func mapLabeledChild(obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[rgbNameLabel]
+	namespace := obj.GetLabels()[rgbNamespaceLabel]
+	if name == "" || namespace == "" || namespace == obj.GetNamespace() {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Namespace: namespace, Name: name},
+	}}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *RGBResourceManagerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	log := r.Log.WithValues("function", "SetupWithManager")
 
-	// Field Indexer for Pod
-	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podOwnerKey, func(rawObj client.Object) []string {
-		// grab the pod object, extract the owner...
-		pod := rawObj.(*corev1.Pod)
-		owner := metav1.GetControllerOf(pod)
-		if owner == nil {
-			return nil
-		}
-		// ...make sure it's a RGBResourceManager...
-		if owner.APIVersion != apiGVStr || owner.Kind != "RGBResourceManager" {
-			return nil
+	// Field Indexer for every child kind, keyed by the owning RGB resource.
+	for _, obj := range ownedTypes() {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), obj, ownerKey, func(rawObj client.Object) []string {
+			// grab the child object, extract the owner...
+			owner := metav1.GetControllerOf(rawObj)
+			if owner == nil {
+				return nil
+			}
+			// ...make sure it's a RGBResourceManager...
+			if owner.APIVersion != apiGVStr || owner.Kind != "RGBResourceManager" {
+				return nil
+			}
+			log.Info("Field Indexer", "Name", owner.Name, "Resource", rawObj.GetName())
+			// ...and if so, return it
+			return []string{owner.Name}
+		}); err != nil {
+			return err
 		}
-		log.Info("Field Indexer", "Name", owner.Name, "Resource", pod.Name)
-		// ...and if so, return it
-		return []string{owner.Name}
-	}); err != nil {
-		return err
 	}
 
 	// Predicates example.
@@ -233,25 +1053,12 @@ func (r *RGBResourceManagerReconciler) SetupWithManager(mgr ctrl.Manager) error
 	}
 
 	deleteFunction := func(e event.DeleteEvent) bool {
-		// Skip delete event when a object has skip=delete label
 		if e.Object == nil {
 			log.Error(nil, "Delete event has no runtime object to delete", "event", e)
 			return false
 		}
-
-		depObj, ok := e.Object.(*appsv1.Deployment)
-		if ok && depObj.Labels["skip"] == "delete" {
-			// skip processing delete as marked.
-			log.Info("Reconcile", "Skipping delete event for Deployment", depObj.Name, "event", e)
-			return false
-		}
-		podObj, ok := e.Object.(*corev1.Pod)
-		if ok && podObj.Labels["skip"] == "delete" {
-			// skip processing delete as marked.
-			log.Info("Reconcile", "Skipping delete event for Pod", podObj.Name, "event", e)
-			return false
-		}
-
+		// Every delete counts, children the controller must not delete
+		// carry the protected annotation and spec.paused freezes them all.
 		return true
 	}
 
@@ -266,71 +1073,62 @@ func (r *RGBResourceManagerReconciler) SetupWithManager(mgr ctrl.Manager) error
 		UpdateFunc: updateFunction,
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	r.expectations = newExpectations()
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&kdv1.RGBResourceManager{}).
-		Owns(&corev1.Pod{}).
-		Owns(&appsv1.Deployment{}).
+		Watches(&source.Kind{Type: &kdv1.RGBColorSet{}}, handler.EnqueueRequestsFromMapFunc(r.mapAllRGBs)).
+		Watches(&source.Kind{Type: &kdv1.RGBPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.mapAllRGBs))
+	for _, obj := range ownedTypes() {
+		builder = builder.
+			Owns(obj).
+			Watches(&source.Kind{Type: obj}, handler.EnqueueRequestsFromMapFunc(mapLabeledChild)).
+			Watches(&source.Kind{Type: obj}, handler.EnqueueRequestsFromMapFunc(r.mapOrphan)).
+			Watches(&source.Kind{Type: obj}, &expectationsHandler{expectations: r.expectations})
+	}
+	c, err := builder.
 		WithEventFilter(p).
-		Complete(r)
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Build(r)
+	if err != nil {
+		return err
+	}
+	r.controller = c
+	r.cache = mgr.GetCache()
+	r.mapper = mgr.GetRESTMapper()
+	r.apiReader = mgr.GetAPIReader()
+	r.watches = map[schema.GroupVersionKind]bool{}
+
+	// Fleet metrics are read from the cache on every scrape.
+	return metrics.Registry.Register(&fleetCollector{reader: mgr.GetClient(), log: log})
 }
 
-func createDeploymentObj(namespace string, name string, replicas int32, labelkey string, labelvalue string) *appsv1.Deployment {
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-			Labels: map[string]string{
-				labelkey: labelvalue,
-			},
-			Namespace: namespace,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					labelkey: labelvalue,
-				},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						labelkey: labelvalue,
-					},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  name,
-							Image: "nginx",
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "http",
-									Protocol:      corev1.ProtocolTCP,
-									ContainerPort: 80,
-								},
-							},
-						},
-					},
-				},
-			},
-		},
+// copyLabels returns a copy of in the caller is free to modify.
+func copyLabels(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
 	}
-	return deployment
+	return out
 }
 
-func createPodObj(namespace string, name string, labelkey string, labelvalue string) *corev1.Pod {
-	deployment := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-			Labels: map[string]string{
-				labelkey: labelvalue,
-			},
-			Namespace: namespace,
-		},
+// mergeLabels returns a copy of base with overrides applied on top.
+func mergeLabels(base map[string]string, overrides map[string]string) map[string]string {
+	out := copyLabels(base)
+	for k, v := range overrides {
+		out[k] = v
+	}
+	return out
+}
+
+// defaultPodTemplate is run by the children when the RGB resource has no
+// spec.template.
+func defaultPodTemplate() corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
 		Spec: corev1.PodSpec{
 			Containers: []corev1.Container{
 				{
-					Name:  name,
-					Image: "nginx",
+					Name:  "nginx",
+					Image: kdv1.DefaultImage,
 					Ports: []corev1.ContainerPort{
 						{
 							Name:          "http",
@@ -342,18 +1140,133 @@ func createPodObj(namespace string, name string, labelkey string, labelvalue str
 			},
 		},
 	}
-	return deployment
 }
 
-func (r *RGBResourceManagerReconciler) markRGBReady(ctx context.Context, log logr.Logger, rgb_resource *kdv1.RGBResourceManager) (ctrl.Result, error) {
-	log.Info("Reconciling RGB", "operation", "update", "rgb-Status", "Ready")
-	// Final state achieved, mark rgb as ready
+// podTemplate returns the pod template the children of the RGB resource run.
+func podTemplate(rgb_resource *kdv1.RGBResourceManager) corev1.PodTemplateSpec {
+	if rgb_resource.Spec.Template != nil {
+		return *rgb_resource.Spec.Template.DeepCopy()
+	}
+	return defaultPodTemplate()
+}
+
+// templateHash fingerprints a pod template as written by the user, before
+// the controller adds its own labels, so recoloring does not change it.
+func templateHash(template corev1.PodTemplateSpec) string {
+	return objectHash(template)
+}
+
+// objectHash fingerprints anything that marshals to JSON.
+func objectHash(obj interface{}) string {
+	hasher := fnv.New32a()
+	data, _ := json.Marshal(obj)
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// isTemplateCurrent reports whether the child was built from the current
+// pod template. Children from before templates existed have no hash, they
+// are only stale once a template is set. Adopted children have an empty
+// one and are never current.
+func isTemplateCurrent(child client.Object, rgb_resource *kdv1.RGBResourceManager, hash string) bool {
+	childHash, ok := child.GetAnnotations()[templateHashAnnotation]
+	if !ok {
+		return rgb_resource.Spec.Template == nil
+	}
+	return childHash == hash
+}
+
+// activeReferences builds the Status.Active entries for the given children,
+// sorted by name so the status only changes when the children do.
+func (r *RGBResourceManagerReconciler) activeReferences(children []client.Object) ([]corev1.ObjectReference, error) {
+	var active []corev1.ObjectReference
+	for _, child := range children {
+		// Objects from the cache carry no TypeMeta, the scheme fills in the kind.
+		ref, err := reference.GetReference(r.Scheme, child)
+		if err != nil {
+			return nil, err
+		}
+		active = append(active, *ref)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].Name < active[j].Name
+	})
+	return active, nil
+}
+
+// setAnnotation sets key to value on obj and reports whether that changed anything.
+func setAnnotation(obj *metav1.ObjectMeta, key string, value string) bool {
+	if v, ok := obj.Annotations[key]; ok && v == value {
+		return false
+	}
+	if obj.Annotations == nil {
+		obj.Annotations = map[string]string{}
+	}
+	obj.Annotations[key] = value
+	return true
+}
+
+// setLabel sets key to value on obj and reports whether that changed anything.
+func setLabel(obj *metav1.ObjectMeta, key string, value string) bool {
+	if v, ok := obj.Labels[key]; ok && v == value {
+		return false
+	}
+	if obj.Labels == nil {
+		obj.Labels = map[string]string{}
+	}
+	obj.Labels[key] = value
+	return true
+}
+
+// setCondition records a condition against the generation being reconciled.
+func setCondition(rgb_resource *kdv1.RGBResourceManager, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&rgb_resource.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: rgb_resource.Generation,
+	})
+}
+
+func markRGBReady(rgb_resource *kdv1.RGBResourceManager, ready int) {
+	message := fmt.Sprintf("%d of %d children ready", ready, rgb_resource.Spec.Count)
+	setCondition(rgb_resource, kdv1.ConditionAvailable, metav1.ConditionTrue, kdv1.ReasonAsExpected, message)
+	setCondition(rgb_resource, kdv1.ConditionProgressing, metav1.ConditionFalse, kdv1.ReasonAsExpected, message)
+	setCondition(rgb_resource, kdv1.ConditionDegraded, metav1.ConditionFalse, kdv1.ReasonAsExpected, "")
 	rgb_resource.Status.Result = kdv1.RGBStatus(kdv1.RGBReady)
+}
+
+func markRGBProgressing(rgb_resource *kdv1.RGBResourceManager, reason string, message string) {
+	setCondition(rgb_resource, kdv1.ConditionAvailable, metav1.ConditionFalse, reason, message)
+	setCondition(rgb_resource, kdv1.ConditionProgressing, metav1.ConditionTrue, reason, message)
+	setCondition(rgb_resource, kdv1.ConditionDegraded, metav1.ConditionFalse, kdv1.ReasonAsExpected, "")
+	rgb_resource.Status.Result = kdv1.RGBStatus(kdv1.RGBInitial)
+}
+
+// markRGBDegraded leaves Available and Progressing as they were, the failure
+// says nothing new about the children.
+func markRGBDegraded(rgb_resource *kdv1.RGBResourceManager, reason string, message string) {
+	setCondition(rgb_resource, kdv1.ConditionDegraded, metav1.ConditionTrue, reason, message)
+}
+
+// updateRGBStatus writes the status back when this pass changed it, so an
+// unchanged RGB does not trigger another reconcile through its own watch.
+func (r *RGBResourceManagerReconciler) updateRGBStatus(ctx context.Context, log logr.Logger, original *kdv1.RGBResourceManager, rgb_resource *kdv1.RGBResourceManager) error {
+	rgb_resource.Status.ObservedGeneration = rgb_resource.Generation
+	if rgb_resource.Status.Result == "" {
+		rgb_resource.Status.Result = kdv1.RGBStatus(kdv1.RGBInitial)
+	}
+	if equality.Semantic.DeepEqual(original.Status, rgb_resource.Status) {
+		return nil
+	}
+
+	log.Info("Reconciling RGB", "operation", "update", "rgb-Status", rgb_resource.Status.Result)
 	err := r.Status().Update(ctx, rgb_resource)
 	if err != nil {
 		log.Info("Reconciling RGB", "operation", "update", "rgb", "Failed")
-		return ctrl.Result{}, err
+		return err
 	}
-	log.Info("Reconciling RGB", "operation", "Updated", "rgb-Status", "Ready")
-	return ctrl.Result{}, nil
+	log.Info("Reconciling RGB", "operation", "Updated", "rgb-Status", rgb_resource.Status.Result)
+	return nil
 }
@@ -0,0 +1,213 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// rankedChild is a child as seen by the scale-down ranking. Children that
+// run pods are judged by all of them, so Pods and workloads rank alike.
+type rankedChild struct {
+	obj client.Object
+	// scheduled and running are true when every pod of the child is.
+	scheduled bool
+	running   bool
+	ready     bool
+	// colored is true when the child carries its assigned color and is not
+	// beyond what the palette asks for.
+	colored  bool
+	restarts int32
+	// lastStart is the latest (re)start of any container of the child.
+	lastStart time.Time
+	// ordinal is the ordinal in the name of the child, -1 without one.
+	ordinal int
+}
+
+// rankForScaleDown returns the children in the order they should be deleted
+// in, according to the scale-down policy of the RGB resource.
+func (r *RGBResourceManagerReconciler) rankForScaleDown(ctx context.Context, m childManager, rgb_resource *kdv1.RGBResourceManager, children []client.Object, colors colorAssignment) ([]client.Object, error) {
+	podsByChild, err := r.podsByChild(ctx, m, rgb_resource, children)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := make([]rankedChild, 0, len(children))
+	for _, child := range children {
+		name := child.GetName()
+		rc := rankedChild{
+			obj:     child,
+			ready:   m.isReady(child),
+			colored: !colors.surplus[name] && child.GetLabels()[colorLabel] == colors.byChild[name],
+			ordinal: -1,
+		}
+		if ordinal, ok := ordinalOf(rgb_resource.Name, name); ok {
+			rc.ordinal = ordinal
+		}
+		summarizePods(&rc, podsByChild[name])
+		ranked = append(ranked, rc)
+	}
+
+	policy := scaleDownPolicy(rgb_resource)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return deleteBefore(policy, ranked[i], ranked[j])
+	})
+	victims := make([]client.Object, 0, len(ranked))
+	for _, rc := range ranked {
+		victims = append(victims, rc.obj)
+	}
+	return victims, nil
+}
+
+// podsByChild returns the pods run by each child, keyed by child name. A Pod
+// child is its own pod, the pods of workloads are found by their labels.
+// Kinds only known at runtime are not looked into.
+func (r *RGBResourceManagerReconciler) podsByChild(ctx context.Context, m childManager, rgb_resource *kdv1.RGBResourceManager, children []client.Object) (map[string][]*corev1.Pod, error) {
+	podsByChild := map[string][]*corev1.Pod{}
+	switch m.(type) {
+	case podManager:
+		for _, child := range children {
+			podsByChild[child.GetName()] = []*corev1.Pod{child.(*corev1.Pod)}
+		}
+	case *unstructuredManager:
+	default:
+		var pods corev1.PodList
+		if err := r.List(ctx, &pods, client.InNamespace(childNamespace(rgb_resource)),
+			client.MatchingLabels{rgbNameLabel: rgb_resource.Name}); err != nil {
+			return nil, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			name := pod.Labels[childNameLabel]
+			podsByChild[name] = append(podsByChild[name], pod)
+		}
+	}
+	return podsByChild, nil
+}
+
+// summarizePods fills in what the ranking needs to know about the pods of a
+// child. A child without pods counts as neither scheduled nor running.
+func summarizePods(rc *rankedChild, pods []*corev1.Pod) {
+	rc.scheduled = len(pods) > 0
+	rc.running = len(pods) > 0
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			rc.scheduled = false
+		}
+		if pod.Status.Phase != corev1.PodRunning {
+			rc.running = false
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.RestartCount > rc.restarts {
+				rc.restarts = status.RestartCount
+			}
+			if status.State.Running != nil && status.State.Running.StartedAt.Time.After(rc.lastStart) {
+				rc.lastStart = status.State.Running.StartedAt.Time
+			}
+		}
+	}
+}
+
+// deleteBefore reports whether a should be deleted before b. The policy
+// decides first, the ReplicaSet controller ranking breaks ties.
+func deleteBefore(policy kdv1.RGBScaleDownPolicy, a rankedChild, b rankedChild) bool {
+	aCreated := a.obj.GetCreationTimestamp()
+	bCreated := b.obj.GetCreationTimestamp()
+	switch policy {
+	case kdv1.ScaleDownNewest:
+		if !aCreated.Equal(&bCreated) {
+			return bCreated.Before(&aCreated)
+		}
+	case kdv1.ScaleDownOldest:
+		if !aCreated.Equal(&bCreated) {
+			return aCreated.Before(&bCreated)
+		}
+	case kdv1.ScaleDownByColor:
+		if a.colored != b.colored {
+			return !a.colored
+		}
+	case kdv1.ScaleDownLeastRecentlyRestarted:
+		if !a.lastStart.Equal(b.lastStart) {
+			return a.lastStart.Before(b.lastStart)
+		}
+	case kdv1.ScaleDownHighestOrdinal:
+		if a.ordinal != b.ordinal {
+			// Children without an ordinal are -1, they go first.
+			if a.ordinal < 0 || b.ordinal < 0 {
+				return a.ordinal < 0
+			}
+			return a.ordinal > b.ordinal
+		}
+	}
+
+	// 1. Unscheduled < scheduled
+	if a.scheduled != b.scheduled {
+		return !a.scheduled
+	}
+	// 2. Not running < running
+	if a.running != b.running {
+		return !a.running
+	}
+	// 3. Not ready < ready
+	if a.ready != b.ready {
+		return !a.ready
+	}
+	// 4. More restarts < fewer restarts
+	if a.restarts != b.restarts {
+		return a.restarts > b.restarts
+	}
+	// 5. Newer < older
+	if !aCreated.Equal(&bCreated) {
+		return bCreated.Before(&aCreated)
+	}
+	return a.obj.GetName() < b.obj.GetName()
+}
+
+// isProtected reports whether the child carries the protected annotation.
+func isProtected(obj client.Object) bool {
+	return obj.GetAnnotations()[kdv1.ProtectedAnnotation] == "true"
+}
+
+// unprotected returns the children the controller may delete, in order.
+func unprotected(children []client.Object) []client.Object {
+	out := make([]client.Object, 0, len(children))
+	for _, child := range children {
+		if !isProtected(child) {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+// scaleDownPolicy returns the scale-down policy of the RGB resource,
+// defaulted like the webhook does for objects it did not see.
+func scaleDownPolicy(rgb_resource *kdv1.RGBResourceManager) kdv1.RGBScaleDownPolicy {
+	if rgb_resource.Spec.ScaleDownPolicy == "" {
+		if namingPolicy(rgb_resource) == kdv1.NamingOrdinal {
+			return kdv1.ScaleDownHighestOrdinal
+		}
+		return kdv1.ScaleDownNotReadyFirst
+	}
+	return rgb_resource.Spec.ScaleDownPolicy
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// serving returns a ranked child that is scheduled, running, ready and
+// colored, created age minutes after a fixed time.
+func serving(name string, age int) rankedChild {
+	return rankedChild{
+		obj:       testPod(name, "Red", age),
+		scheduled: true,
+		running:   true,
+		ready:     true,
+		colored:   true,
+		ordinal:   -1,
+	}
+}
+
+func TestDeleteBefore(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2021, 1, 1, 1, minute, 0, 0, time.UTC) }
+	with := func(rc rankedChild, change func(*rankedChild)) rankedChild {
+		change(&rc)
+		return rc
+	}
+	tests := []struct {
+		name   string
+		policy kdv1.RGBScaleDownPolicy
+		a, b   rankedChild
+		want   bool
+	}{
+		{
+			name:   "unscheduled first",
+			policy: kdv1.ScaleDownNotReadyFirst,
+			a:      with(serving("a", 0), func(rc *rankedChild) { rc.scheduled = false }),
+			b:      serving("b", 1),
+			want:   true,
+		},
+		{
+			name:   "not running first",
+			policy: kdv1.ScaleDownNotReadyFirst,
+			a:      serving("a", 1),
+			b:      with(serving("b", 0), func(rc *rankedChild) { rc.running = false }),
+			want:   false,
+		},
+		{
+			name:   "not ready first",
+			policy: kdv1.ScaleDownNotReadyFirst,
+			a:      with(serving("a", 0), func(rc *rankedChild) { rc.ready = false }),
+			b:      serving("b", 1),
+			want:   true,
+		},
+		{
+			name:   "more restarts first",
+			policy: kdv1.ScaleDownNotReadyFirst,
+			a:      with(serving("a", 0), func(rc *rankedChild) { rc.restarts = 3 }),
+			b:      with(serving("b", 1), func(rc *rankedChild) { rc.restarts = 1 }),
+			want:   true,
+		},
+		{
+			name:   "newer first",
+			policy: kdv1.ScaleDownNotReadyFirst,
+			a:      serving("a", 0),
+			b:      serving("b", 1),
+			want:   false,
+		},
+		{
+			name:   "name breaks the last tie",
+			policy: kdv1.ScaleDownNotReadyFirst,
+			a:      serving("a", 0),
+			b:      serving("b", 0),
+			want:   true,
+		},
+		{
+			name:   "Newest goes before readiness",
+			policy: kdv1.ScaleDownNewest,
+			a:      serving("a", 1),
+			b:      with(serving("b", 0), func(rc *rankedChild) { rc.ready = false }),
+			want:   true,
+		},
+		{
+			name:   "Oldest goes before readiness",
+			policy: kdv1.ScaleDownOldest,
+			a:      serving("a", 0),
+			b:      with(serving("b", 1), func(rc *rankedChild) { rc.ready = false }),
+			want:   true,
+		},
+		{
+			name:   "ByColor deletes the uncolored first",
+			policy: kdv1.ScaleDownByColor,
+			a:      serving("a", 0),
+			b:      with(serving("b", 1), func(rc *rankedChild) { rc.colored = false }),
+			want:   false,
+		},
+		{
+			name:   "ByColor falls back to the ReplicaSet ranking",
+			policy: kdv1.ScaleDownByColor,
+			a:      with(serving("a", 0), func(rc *rankedChild) { rc.ready = false }),
+			b:      serving("b", 1),
+			want:   true,
+		},
+		{
+			name:   "LeastRecentlyRestarted deletes the earlier start first",
+			policy: kdv1.ScaleDownLeastRecentlyRestarted,
+			a:      with(serving("a", 1), func(rc *rankedChild) { rc.lastStart = at(5) }),
+			b:      with(serving("b", 0), func(rc *rankedChild) { rc.lastStart = at(10) }),
+			want:   true,
+		},
+		{
+			name:   "HighestOrdinal deletes the higher ordinal first",
+			policy: kdv1.ScaleDownHighestOrdinal,
+			a:      with(serving("rgb-2", 0), func(rc *rankedChild) { rc.ordinal = 2 }),
+			b:      with(serving("rgb-10", 1), func(rc *rankedChild) { rc.ordinal = 10 }),
+			want:   false,
+		},
+		{
+			name:   "HighestOrdinal deletes children without an ordinal first",
+			policy: kdv1.ScaleDownHighestOrdinal,
+			a:      serving("rgb-0c6f3d0e", 0),
+			b:      with(serving("rgb-7", 1), func(rc *rankedChild) { rc.ordinal = 7 }),
+			want:   true,
+		},
+		{
+			name:   "HighestOrdinal ranks children without an ordinal like the ReplicaSet",
+			policy: kdv1.ScaleDownHighestOrdinal,
+			a:      serving("rgb-0c6f3d0e", 0),
+			b:      with(serving("rgb-6a1e9b4f", 1), func(rc *rankedChild) { rc.ready = false }),
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deleteBefore(tt.policy, tt.a, tt.b); got != tt.want {
+				t.Errorf("deleteBefore(a, b) = %v, want %v", got, tt.want)
+			}
+			if tt.a.obj.GetName() != tt.b.obj.GetName() {
+				if got := deleteBefore(tt.policy, tt.b, tt.a); got == tt.want {
+					t.Errorf("deleteBefore(b, a) = %v, want %v", got, !tt.want)
+				}
+			}
+		})
+	}
+}
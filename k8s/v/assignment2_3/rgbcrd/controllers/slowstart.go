@@ -0,0 +1,66 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+)
+
+const (
+	// slowStartInitialBatchSize is the size of the first batch of creates.
+	slowStartInitialBatchSize = 1
+	// maxParallelCreates bounds the creates in flight for one RGB resource.
+	maxParallelCreates = 16
+)
+
+// slowStartBatch calls fn count times, in batches that double in size as
+// long as every call of the previous batch succeeded: 1, 2, 4, ... up to
+// maxParallelCreates at a time. The calls of a batch run in parallel. A
+// failing batch ends the run, so an error that hits every call, such as an
+// exhausted quota, costs one call rather than count of them. It returns the
+// number of successful calls and the first error.
+func slowStartBatch(count int, initialBatchSize int, fn func(i int) error) (int, error) {
+	successes := 0
+	next := 0
+	for batchSize := minInt(count, initialBatchSize); batchSize > 0; batchSize = minInt(minInt(2*batchSize, count-next), maxParallelCreates) {
+		errCh := make(chan error, batchSize)
+		var wg sync.WaitGroup
+		wg.Add(batchSize)
+		for i := next; i < next+batchSize; i++ {
+			go func(i int) {
+				defer wg.Done()
+				if err := fn(i); err != nil {
+					errCh <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		next += batchSize
+		successes += batchSize - len(errCh)
+		if len(errCh) > 0 {
+			return successes, <-errCh
+		}
+	}
+	return successes, nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestSlowStartBatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		count         int
+		initial       int
+		failing       map[int]bool
+		wantCalls     int
+		wantSuccesses int
+		wantErr       bool
+	}{
+		{
+			name: "nothing to do",
+		},
+		{
+			name:          "all succeed",
+			count:         10,
+			initial:       1,
+			wantCalls:     10,
+			wantSuccesses: 10,
+		},
+		{
+			name:      "the first call fails",
+			count:     10,
+			initial:   1,
+			failing:   map[int]bool{0: true},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			// Batches of 1, 2 and 4, the third one fails.
+			name:          "the rest of the failing batch still runs",
+			count:         10,
+			initial:       1,
+			failing:       map[int]bool{3: true},
+			wantCalls:     7,
+			wantSuccesses: 6,
+			wantErr:       true,
+		},
+		{
+			name:          "every failure of a batch counts",
+			count:         10,
+			initial:       1,
+			failing:       map[int]bool{1: true, 2: true},
+			wantCalls:     3,
+			wantSuccesses: 1,
+			wantErr:       true,
+		},
+		{
+			name:          "a larger first batch",
+			count:         10,
+			initial:       4,
+			failing:       map[int]bool{0: true},
+			wantCalls:     4,
+			wantSuccesses: 3,
+			wantErr:       true,
+		},
+		{
+			// Batches of 1, 2, 4, 8, 16, 16: the sixth would be 32 uncapped.
+			name:          "batches stop growing at maxParallelCreates",
+			count:         100,
+			initial:       1,
+			failing:       map[int]bool{40: true},
+			wantCalls:     47,
+			wantSuccesses: 46,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			successes, err := slowStartBatch(tt.count, tt.initial, func(i int) error {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+				if tt.failing[i] {
+					return errors.New("failed")
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if successes != tt.wantSuccesses {
+				t.Errorf("successes = %d, want %d", successes, tt.wantSuccesses)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kdv1 "kb.example.com/rgbcrd/api/v1"
+)
+
+// errClusterScoped is returned for a manifest of a kind that does not live
+// in a namespace, such children could not be told apart per RGB resource.
+var errClusterScoped = errors.New("kind is cluster-scoped")
+
+// unstructuredManager manages children of any namespaced kind, built from the
+// manifest of a RGB resource. It knows nothing about the kind: changes to the
+// manifest are copied onto the children and readiness is read from the
+// conventional status fields.
+type unstructuredManager struct {
+	baseManager
+	gvk      schema.GroupVersionKind
+	manifest map[string]interface{}
+	// hash fingerprints the manifest, it takes the place of the template hash.
+	hash string
+}
+
+// newUnstructuredManager resolves the kind named by the RGB resource through
+// mapper and returns a manager for its manifest.
+func newUnstructuredManager(rgb_resource *kdv1.RGBResourceManager, mapper meta.RESTMapper) (*unstructuredManager, error) {
+	gvk := rgb_resource.ChildGroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return nil, errClusterScoped
+	}
+
+	var manifest map[string]interface{}
+	if err := json.Unmarshal(rgb_resource.Spec.Manifest.Raw, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	// Name, namespace and status are never taken from the manifest.
+	unstructured.RemoveNestedField(manifest, "metadata", "name")
+	unstructured.RemoveNestedField(manifest, "metadata", "generateName")
+	unstructured.RemoveNestedField(manifest, "metadata", "namespace")
+	delete(manifest, "status")
+
+	return &unstructuredManager{
+		gvk:      mapping.GroupVersionKind,
+		manifest: manifest,
+		hash:     objectHash(manifest),
+	}, nil
+}
+
+func (m *unstructuredManager) kind() kdv1.RGBSupportedKind { return kdv1.RGBSupportedKind(m.gvk.Kind) }
+func (m *unstructuredManager) op() string                  { return strings.ToLower(m.gvk.Kind) }
+func (m *unstructuredManager) indexed() bool               { return false }
+
+func (m *unstructuredManager) object() client.Object {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(m.gvk)
+	return u
+}
+
+func (m *unstructuredManager) list(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]client.Object, error) {
+	var childList unstructured.UnstructuredList
+	childList.SetGroupVersionKind(m.gvk.GroupVersion().WithKind(m.gvk.Kind + "List"))
+	if err := c.List(ctx, &childList, opts...); err != nil {
+		return nil, err
+	}
+	children := make([]client.Object, 0, len(childList.Items))
+	for i := range childList.Items {
+		children = append(children, &childList.Items[i])
+	}
+	return children, nil
+}
+
+// build ignores template, the manifest describes the whole child.
+func (m *unstructuredManager) build(namespace string, name string, labels map[string]string, template corev1.PodTemplateSpec) client.Object {
+	u := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(m.manifest)}
+	u.SetGroupVersionKind(m.gvk)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	u.SetLabels(mergeLabels(u.GetLabels(), labels))
+	u.SetAnnotations(mergeLabels(u.GetAnnotations(), map[string]string{
+		templateHashAnnotation: m.hash,
+	}))
+	return u
+}
+
+// isReady trusts the kind's own Ready or Available condition when it has
+// one. Kinds without either are ready once their controller, if any, has
+// observed the latest generation.
+func (m *unstructuredManager) isReady(obj client.Object) bool {
+	u := obj.(*unstructured.Unstructured)
+	if observed, found, _ := unstructured.NestedInt64(u.Object, "status", "observedGeneration"); found && observed < u.GetGeneration() {
+		return false
+	}
+	conditions, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if t := condition["type"]; t == "Ready" || t == "Available" {
+			return condition["status"] == string(corev1.ConditionTrue)
+		}
+	}
+	return true
+}
+
+func (m *unstructuredManager) isUpToDate(obj client.Object, color string) bool {
+	return obj.GetLabels()[colorLabel] == color
+}
+
+func (m *unstructuredManager) recolor(obj client.Object, color string) bool {
+	objLabels := obj.GetLabels()
+	if v, ok := objLabels[colorLabel]; ok && v == color {
+		return false
+	}
+	obj.SetLabels(mergeLabels(objLabels, map[string]string{colorLabel: color}))
+	return true
+}
+
+// retemplate only records the manifest hash. The reconciler applies the
+// manifest server-side, which keeps the fields the API server or others set
+// where rebuilding the child would clear them.
+func (m *unstructuredManager) retemplate(obj client.Object, template corev1.PodTemplateSpec) bool {
+	obj.SetAnnotations(mergeLabels(obj.GetAnnotations(), map[string]string{
+		templateHashAnnotation: m.hash,
+	}))
+	return true
+}
@@ -52,11 +52,14 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var maxConcurrentReconciles int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of RGB resources reconciled at the same time.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -79,13 +82,31 @@ func main() {
 	}
 
 	if err = (&controllers.RGBResourceManagerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Log:    ctrl.Log.WithName("controllers").WithName("rgb"),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("rgb"),
+		Recorder:                mgr.GetEventRecorderFor("rgbresourcemanager-controller"),
+		MaxConcurrentReconciles: maxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "RGBResourceManager")
 		os.Exit(1)
 	}
+	// Webhooks are served by the manager's webhook server on port 9443.
+	// Set ENABLE_WEBHOOKS=false to run the manager locally without certificates.
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&kdv1.RGBResourceManager{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "RGBResourceManager")
+			os.Exit(1)
+		}
+		if err = (&kdv1.RGBColorSet{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "RGBColorSet")
+			os.Exit(1)
+		}
+		if err = (&kdv1.RGBPolicy{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "RGBPolicy")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {